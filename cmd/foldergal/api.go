@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/gallery"
+	"specto.org/projects/foldergal/internal/templates"
+)
+
+type jsonLink struct {
+	Title string `json:"title"`
+	Url   string `json:"url"`
+}
+
+type jsonItem struct {
+	Name       string     `json:"name"`
+	Title      string     `json:"title,omitempty"`
+	Caption    string     `json:"caption,omitempty"`
+	Alt        string     `json:"alt,omitempty"`
+	Class      string     `json:"class"`
+	Url        string     `json:"url"`
+	File       string     `json:"file,omitempty"`
+	Thumb      string     `json:"thumb,omitempty"`
+	Motion     string     `json:"motion,omitempty"`
+	ModTime    time.Time  `json:"mtime"`
+	Size       int64      `json:"size"`
+	Width      int        `json:"width,omitempty"`
+	Height     int        `json:"height,omitempty"`
+	Duration   float64    `json:"duration,omitempty"` // Seconds
+	Rating     int        `json:"rating,omitempty"`
+	Pinned     bool       `json:"pinned,omitempty"`
+	Keywords   []string   `json:"keywords,omitempty"`
+	Alternates []jsonLink `json:"alternates,omitempty"`
+}
+
+type jsonList struct {
+	Title       string                 `json:"title"`
+	Parent      string                 `json:"parent,omitempty"`
+	BreadCrumbs []jsonLink             `json:"breadcrumbs"`
+	Settings    *config.FolderSettings `json:"settings,omitempty"`
+	Items       []jsonItem             `json:"items"`
+	Page        int                    `json:"page"`
+	Pages       int                    `json:"pages"`
+	Total       int                    `json:"total"`
+	Prev        string                 `json:"prev,omitempty"`
+	Next        string                 `json:"next,omitempty"`
+}
+
+type jsonView struct {
+	Item        jsonItem               `json:"item"`
+	Parent      string                 `json:"parent"`
+	Prev        string                 `json:"prev,omitempty"`
+	Next        string                 `json:"next,omitempty"`
+	BreadCrumbs []jsonLink             `json:"breadcrumbs"`
+	Settings    *config.FolderSettings `json:"settings,omitempty"`
+}
+
+// Checks if the client asked for JSON instead of html
+func wantsJson(r *http.Request) bool {
+	q, _ := parseQuery(r.URL.RawQuery)
+	return q.Has("json") || r.URL.Query().Has("json") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJson(w http.ResponseWriter, r *http.Request, data any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Vary", "Accept")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		fail500(w, err, r)
+	}
+}
+
+func toJsonItem(ctx context.Context, item templates.ListItem) jsonItem {
+	out := jsonItem{
+		Name:     item.Name,
+		Title:    item.Title,
+		Caption:  item.Caption,
+		Alt:      item.Alt,
+		Url:      item.Url,
+		Motion:   item.Motion,
+		ModTime:  item.ModTime,
+		Size:     item.Size,
+		Rating:   item.Rating,
+		Pinned:   item.Pinned,
+		Keywords: item.Keywords,
+		Class:    item.BaseClass(),
+	}
+	if item.IsFolder() {
+		return out
+	}
+	// Links of items may carry the request settings
+	escapedPath, _, _ := strings.Cut(item.Url, "?")
+	out.File = fileUrl(escapedPath)
+	out.Thumb = escapedPath + "?thumb"
+	info := gallery.ReadInfo(ctx, item.Path)
+	out.Width = info.Width
+	out.Height = info.Height
+	out.Duration = info.Duration.Seconds()
+	for _, alternate := range item.Alternates {
+		out.Alternates = append(out.Alternates,
+			jsonLink{Title: alternate.Name, Url: alternate.Url})
+	}
+	return out
+}
+
+func toJsonLinks(crumbs []templates.BreadCrumb) []jsonLink {
+	links := make([]jsonLink, 0, len(crumbs))
+	for _, crumb := range crumbs {
+		links = append(links, jsonLink{Title: crumb.Title, Url: crumb.Url})
+	}
+	return links
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -17,10 +25,14 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"unicode"
 
 	"specto.org/projects/foldergal/internal/config"
 	"specto.org/projects/foldergal/internal/gallery"
@@ -36,6 +48,8 @@ type ctxKey string
 const (
 	reqSettings    ctxKey = "reqSettings"
 	folderSettings ctxKey = "folderSettings"
+	currentUser    ctxKey = "currentUser"
+	folderAccess   ctxKey = "folderAccess"
 )
 
 type feed string
@@ -60,6 +74,13 @@ var (
 	feedNotFreshCount = 20                  // entries to show in RSS if not fresh
 	headerTimeout     = 3 * time.Second
 	shutdownTimeout   = 10 * time.Second
+	preferencesCookie = "foldergal_prefs"
+	preferencesMaxAge = 365 * 24 * time.Hour
+	sessionCookie     = "foldergal_session"
+	sessionMaxAge     = 30 * 24 * time.Hour
+	unlockCookie      = "foldergal_unlock_" // and a hash of the folder
+	shareCookie       = "foldergal_share_"  // and the id of the link
+	maxShareDuration  = 366 * 24 * time.Hour
 )
 
 // Verify if a file exists and is not a folder
@@ -138,7 +159,7 @@ func previewHandler(w http.ResponseWriter, r *http.Request) {
 		staticHandler("res/broken.svg", w, r)
 		return
 	}
-	if err != nil {
+	if err != nil || gallery.IsExcludedPath(fullPath) {
 		w.WriteHeader(http.StatusNotFound)
 		staticHandler("res/broken.svg", w, r)
 		return
@@ -172,6 +193,38 @@ func previewHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, file.ThumbPath(), file.ThumbModTime(), thumb)
 }
 
+// Location in the cache for videos extracted from motion photos
+func motionCachePath(fullPath string) string {
+	return sanitizePath(fullPath) + ".mp4"
+}
+
+// Route for the video embedded in motion photos
+func motionHandler(w http.ResponseWriter, r *http.Request) {
+	if gallery.IsExcludedPath(strings.TrimPrefix(r.URL.Path, urlPrefix)) {
+		fail404(w, r)
+		return
+	}
+	fullPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	clip, err := gallery.NewMotionPhoto(fullPath, motionCachePath(fullPath))
+	if err != nil {
+		fail404(w, r)
+		return
+	}
+	if err := gallery.GenerateThumb(clip); err != nil {
+		fail500(w, err, r)
+		return
+	}
+	contents, err := clip.Thumb()
+	if err != nil {
+		fail500(w, err, r)
+		return
+	}
+	defer contents.Close()
+
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeContent(w, r, clip.ThumbPath(), clip.ThumbModTime(), contents)
+}
+
 // Splits a url "path" to separate tokens
 func splitUrlToBreadCrumbs(pageUrl *url.URL, qs string) (crumbs []templates.BreadCrumb) {
 	deepcrumb := urlPrefix + "/"
@@ -188,10 +241,33 @@ func splitUrlToBreadCrumbs(pageUrl *url.URL, qs string) (crumbs []templates.Brea
 	return
 }
 
-// Counts recursively all valid media files in startPath
-func mediaCount(startPath string) (totalCount int64) {
+// Checks if an entry met while walking the root folder is hidden, or is
+// a folder the user cannot see
+func excludedWalkEntry(ignored *gallery.IgnoreWalker, access *config.FolderAccess,
+	osPath string, entry os.DirEntry) bool {
+	relPath, err := filepath.Rel(config.Global.Root, osPath)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return false // Outside of the gallery, like the cache
+	}
+	return ignored.Excludes(relPath, entry.IsDir()) ||
+		(entry.IsDir() && !access.Allows(filepath.ToSlash(relPath)))
+}
+
+// Counts recursively all valid media files in startPath which the user
+// can see
+func mediaCount(startPath string, access *config.FolderAccess) (totalCount int64) {
+	ignored := gallery.NewIgnoreWalker()
 	_ = filepath.WalkDir(startPath,
 		func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if excludedWalkEntry(ignored, access, path, entry) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 			if !entry.IsDir() && gallery.IsValidMedia(path) {
 				totalCount += 1
 			}
@@ -200,10 +276,20 @@ func mediaCount(startPath string) (totalCount int64) {
 	return
 }
 
-// Retrieves the byte size of media files in startPath
-func folderMediaSize(startPath string) (totalSize int64) {
+// Retrieves the byte size of media files in startPath which the user can see
+func folderMediaSize(startPath string, access *config.FolderAccess) (totalSize int64) {
+	ignored := gallery.NewIgnoreWalker()
 	_ = filepath.WalkDir(startPath,
 		func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if excludedWalkEntry(ignored, access, path, entry) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 			if !entry.IsDir() && gallery.IsValidMedia(path) {
 				if info, err1 := entry.Info(); err1 == nil {
 					totalSize += info.Size()
@@ -219,9 +305,10 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
-	fileCount := mediaCount(config.Global.Root)
-	folderSize := folderMediaSize(config.Global.Root)
-	thumbSize := folderMediaSize(config.Global.Cache)
+	access := requestAccess(r)
+	fileCount := mediaCount(config.Global.Root, access)
+	folderSize := folderMediaSize(config.Global.Root, access)
+	thumbSize := folderMediaSize(config.Global.Cache, access)
 	cacheExpires := time.Duration(config.Global.CacheExpiresAfter).String()
 	if config.Global.CacheExpiresAfter == 0 {
 		cacheExpires = "cache is disabled"
@@ -232,6 +319,7 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		{"Media Folder Size:", fmt.Sprintf("%v MiB", folderSize/1024/1024)},
 		{"Thumbnail Folder Size:", fmt.Sprintf("%v MiB", thumbSize/1024/1024)},
 		{"Folders Watched:", fmt.Sprint(gallery.WatchedFolders)},
+		{"Indexed for Search:", fmt.Sprint(gallery.IndexSize())},
 		{"Public Url:", config.Global.PublicUrl},
 		{"Prefix:", config.Global.Prefix},
 		{"Cache Expires After:", cacheExpires},
@@ -245,132 +333,1278 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		{"Service Uptime:", time.Since(startTime).String()},
 	}
 
-	page := templates.TwoColTable{
-		Page: templates.Page{
-			Title:        "System Status",
-			Prefix:       urlPrefix,
-			AppVersion:   BuildVersion,
-			AppBuildTime: BuildTimestamp,
-		},
-		Rows: rowData,
+	page := templates.TwoColTable{
+		Page: templates.Page{
+			Title:        "System Status",
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+		},
+		Rows: rowData,
+	}
+	if err := templates.Html.ExecuteTemplate(w, "table", &page); err != nil {
+		fail500(w, err, r)
+	}
+}
+
+// Link to the file contents of media
+func fileUrl(escapedPath string) string {
+	return fmt.Sprintf("%s?%s/%s",
+		escapedPath, config.QKeyDisplay, config.QueryDisplayFile)
+}
+
+// Settings of a folder inherited from its parents and its own
+func folderSettingsOf(folderPath string) config.FolderSettings {
+	meta, err := config.InheritedFolderSettings(folderPath)
+	if err != nil {
+		logger.Printf("metadata error: %v\n", err)
+	}
+	return meta
+}
+
+// Extensions of files grouped by base name in a folder
+func groupExtensions(folderPath string) []string {
+	if meta := folderSettingsOf(folderPath); meta.Group != nil {
+		return meta.Group
+	}
+	return config.Global.GroupExtensions
+}
+
+// Prepares a list item of a folder or a media file without its details
+func newListItem(itemPath string, isDir bool, modTime time.Time, size int64) templates.ListItem {
+	itemUrl := gallery.EscapePath(filepath.Join(urlPrefix, itemPath))
+	item := templates.ListItem{
+		Id:      gallery.EscapePath(path.Base(itemPath)),
+		ModTime: modTime,
+		Url:     itemUrl,
+		Path:    itemPath,
+		Name:    path.Base(itemPath),
+		Thumb:   urlPrefix + "/?static/ui.svg#iconFolder",
+		Class:   "folder",
+		W:       config.Global.ThumbWidth,
+		H:       config.Global.ThumbHeight,
+	}
+	if !isDir {
+		item.Size = size
+		item.Thumb = itemUrl + "?thumb"
+		item.Class = string(gallery.GetMediaClass(itemPath))
+		item.Type = strings.ToUpper(strings.TrimPrefix(path.Ext(itemPath), "."))
+		if config.Global.Ffmpeg == "" {
+			item.Class += " nothumb"
+		}
+	}
+	return item
+}
+
+// Groups the files of a folder. Files sharing a base name are grouped under
+// the preferred one and Live Photo clips are paired with their still image.
+// Both alternates and clips are hidden.
+func groupFolderFiles(folderPath string, names []string) (
+	groups map[string][]string, livePairs map[string]string, hidden map[string]bool) {
+	groups = gallery.GroupFiles(names, groupExtensions(folderPath))
+	hidden = make(map[string]bool)
+	for _, alternates := range groups {
+		for _, name := range alternates {
+			hidden[name] = true
+		}
+	}
+	primaries := make([]string, 0, len(names))
+	for _, name := range names {
+		if !hidden[name] {
+			primaries = append(primaries, name)
+		}
+	}
+	livePairs = gallery.PairLivePhotos(primaries)
+	for _, clip := range livePairs {
+		hidden[clip] = true
+	}
+	return
+}
+
+// Prepares the visible children of a folder as list items (not sorted).
+// Subfolders are left out when the access rules do not let the user in.
+// Alternates and Live Photo clips are folded into the preferred item.
+func folderItems(folderPath string, contents []os.FileInfo, access *config.FolderAccess) []templates.ListItem {
+	ignored := gallery.NewIgnoreWalker()
+	visible := make([]os.FileInfo, 0, len(contents))
+	for _, child := range contents {
+		childPath := path.Join(folderPath, child.Name())
+		if !ignored.Excludes(childPath, child.IsDir()) &&
+			(!child.IsDir() || access.Allows(childPath)) {
+			visible = append(visible, child)
+		}
+	}
+	contents = visible
+	names := make([]string, 0, len(contents))
+	for _, child := range contents {
+		if !child.IsDir() {
+			names = append(names, child.Name())
+		}
+	}
+	groups, livePairs, hidden := groupFolderFiles(folderPath, names)
+	sidecars := gallery.FindSidecars(names)
+	captionFiles := gallery.FindCaptionFiles(names)
+
+	items := make([]templates.ListItem, 0, len(contents))
+	for _, child := range contents {
+		if hidden[child.Name()] {
+			continue
+		}
+		mediaClass := gallery.GetMediaClass(child.Name())
+		if !child.IsDir() && mediaClass == "" {
+			continue
+		}
+		item := newListItem(path.Join(folderPath, child.Name()),
+			child.IsDir(), child.ModTime(), child.Size())
+		if !child.IsDir() {
+			if clip, ok := livePairs[child.Name()]; ok {
+				item.Motion = fileUrl(gallery.EscapePath(
+					filepath.Join(urlPrefix, folderPath, clip)))
+				item.Class += " live"
+			}
+			sidecar := sidecars[child.Name()]
+			captionFile := captionFiles[child.Name()]
+			for _, name := range groups[child.Name()] {
+				item.Alternates = append(item.Alternates, templates.Alternate{
+					Name: name,
+					Url: fileUrl(gallery.EscapePath(
+						filepath.Join(urlPrefix, folderPath, name))),
+				})
+				if sidecar == "" {
+					sidecar = sidecars[name]
+				}
+				if captionFile == "" {
+					captionFile = captionFiles[name]
+				}
+			}
+			if sidecar != "" {
+				item.Sidecar = path.Join(folderPath, sidecar)
+			}
+			if captionFile != "" {
+				item.CaptionFile = path.Join(folderPath, captionFile)
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// Reads the contents of a folder
+func readFolder(folderPath string) ([]os.FileInfo, error) {
+	fs, err := storage.Root.Open(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+	return fs.Readdir(-1)
+}
+
+// Collects the items shown in a folder: its children or, in the recursive
+// display mode, the media in it and its subfolders up to opts.Depth levels
+func listItems(folderPath string, contents []os.FileInfo, opts config.RequestSettings, access *config.FolderAccess) []templates.ListItem {
+	if opts.Display != config.QueryDisplayRecursive {
+		return folderItems(folderPath, contents, access)
+	}
+	levels := opts.Depth
+	if levels == 0 {
+		levels = -1 // All the way down
+	}
+	items := recursiveItems(folderPath, contents, levels, access)
+	base := path.Clean("/" + folderPath)
+	for i := range items {
+		// Names can repeat in subfolders, the relative path is unique
+		if rel, err := filepath.Rel(base, path.Clean("/"+items[i].Path)); err == nil {
+			items[i].Id = gallery.EscapePath(rel)
+		}
+	}
+	return items
+}
+
+func recursiveItems(folderPath string, contents []os.FileInfo, levels int, access *config.FolderAccess) []templates.ListItem {
+	var items []templates.ListItem
+	for _, item := range folderItems(folderPath, contents, access) {
+		if !item.IsFolder() {
+			items = append(items, item)
+			continue
+		}
+		if levels == 0 {
+			continue
+		}
+		subContents, err := readFolder(item.Path)
+		if err != nil {
+			logger.Print(err)
+			continue
+		}
+		items = append(items, recursiveItems(item.Path, subContents, levels-1, access)...)
+	}
+	return items
+}
+
+// Settings for the link to an item listed in a folder. In the recursive
+// display mode media links keep how far up is the listed folder.
+func itemSettings(opts config.RequestSettings, folderPath, itemPath string) *config.RequestSettings {
+	up := 0
+	if opts.Display == config.QueryDisplayRecursive {
+		up = levelsUp(folderPath, itemPath)
+	}
+	return opts.WithPage(0).WithUp(up)
+}
+
+// How many levels up from the folder of an item is a folder above it
+func levelsUp(folderPath, itemPath string) int {
+	rel, err := filepath.Rel(path.Clean("/"+folderPath),
+		path.Dir(path.Clean("/"+itemPath)))
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// Reads the XMP metadata of media items and their descriptions.
+// Titles and captions from folder settings win over caption files,
+// which win over XMP.
+func addMetadata(items []templates.ListItem) {
+	folders := make(map[string]config.FolderSettings)
+	for i := range items {
+		item := &items[i]
+		if item.IsFolder() {
+			continue
+		}
+		meta := gallery.ReadMetadata(item.Path, item.Sidecar)
+		item.Title = meta.Title
+		item.Caption = meta.Caption
+		item.Keywords = meta.Keywords
+		item.Rating = meta.Rating
+		if item.CaptionFile != "" {
+			if caption := gallery.ReadCaption(item.CaptionFile); caption != "" {
+				item.Caption = caption
+			}
+		}
+		folderPath := path.Dir(item.Path)
+		settings, ok := folders[folderPath]
+		if !ok {
+			settings = folderSettingsOf(folderPath)
+			folders[folderPath] = settings
+		}
+		file := settings.Files[path.Base(item.Path)]
+		if file.Title != "" {
+			item.Title = file.Title
+		}
+		if file.Caption != "" {
+			item.Caption = file.Caption
+		}
+		item.Alt = file.Alt
+	}
+}
+
+// Checks if filters by metadata are requested
+func hasMetadataFilter(opts config.RequestSettings) bool {
+	return opts.Rating > 0 || opts.Keyword != ""
+}
+
+// Letters of media classes in the class filter
+var classLetters = map[gallery.MediaClass]config.QTypeClass{
+	gallery.MediaImage: config.QueryClassImage,
+	gallery.MediaVideo: config.QueryClassVideo,
+	gallery.MediaAudio: config.QueryClassAudio,
+	gallery.MediaPdf:   config.QueryClassPdf,
+}
+
+// Checks if media of a class is shown by the class filter
+func isClassShown(class gallery.MediaClass, opts config.RequestSettings) bool {
+	return opts.HasClass(classLetters[class])
+}
+
+// Keeps folders and the media matching the class and metadata filters
+func filterItems(items []templates.ListItem, opts config.RequestSettings) []templates.ListItem {
+	if !hasMetadataFilter(opts) && opts.Classes == "" {
+		return items
+	}
+	filtered := make([]templates.ListItem, 0, len(items))
+	for _, item := range items {
+		if !item.IsFolder() {
+			if !isClassShown(gallery.MediaClass(item.BaseClass()), opts) {
+				continue
+			}
+			if item.Rating < opts.Rating {
+				continue
+			}
+			if opts.Keyword != "" && !slices.ContainsFunc(item.Keywords,
+				func(k string) bool { return strings.EqualFold(k, opts.Keyword) }) {
+				continue
+			}
+		}
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
+
+// Links for toggling media classes, shown only in folders with mixed media
+func classLinks(items []templates.ListItem, opts config.RequestSettings) []templates.Link {
+	present := make(map[config.QTypeClass]bool)
+	for _, item := range items {
+		if !item.IsFolder() {
+			present[classLetters[gallery.MediaClass(item.BaseClass())]] = true
+		}
+	}
+	if opts.Classes == "" && len(present) < 2 {
+		return nil
+	}
+	names := make(map[config.QTypeClass]gallery.MediaClass, len(classLetters))
+	for class, letter := range classLetters {
+		names[letter] = class
+	}
+	links := make([]templates.Link, 0, len(config.QueryClasses))
+	for _, letter := range config.QueryClasses {
+		if !present[letter] && !strings.Contains(opts.Classes, string(letter)) {
+			continue
+		}
+		links = append(links, templates.Link{
+			Title:   string(names[letter]),
+			Url:     opts.WithClassToggled(letter).QueryFull(),
+			Current: opts.Classes != "" && opts.HasClass(letter),
+		})
+	}
+	return links
+}
+
+// Links for filtering by rating, shown only when there are rated items
+func ratingLinks(items []templates.ListItem, opts config.RequestSettings) []templates.Link {
+	if opts.Rating == 0 && !slices.ContainsFunc(items,
+		func(item templates.ListItem) bool { return item.Rating > 0 }) {
+		return nil
+	}
+	links := make([]templates.Link, 0, config.MaxRating+1)
+	for rating := range config.MaxRating + 1 {
+		title := "any"
+		if rating > 0 {
+			title = fmt.Sprintf("%d★", rating)
+		}
+		links = append(links, templates.Link{
+			Title:   title,
+			Url:     opts.WithRating(rating).QueryFull(),
+			Current: opts.Rating == rating,
+		})
+	}
+	return links
+}
+
+// Maximum count of keywords offered for filtering in a folder
+var maxKeywordLinks = 20
+
+// Links for filtering by the most used keywords. The current one clears it.
+func keywordLinks(items []templates.ListItem, opts config.RequestSettings) []templates.Link {
+	counts := make(map[string]int)
+	for _, item := range items {
+		for _, keyword := range item.Keywords {
+			counts[strings.ToLower(keyword)]++
+		}
+	}
+	if opts.Keyword != "" {
+		counts[opts.Keyword] = math.MaxInt
+	}
+	keywords := slices.Collect(maps.Keys(counts))
+	sort.Slice(keywords, func(i, j int) bool {
+		if counts[keywords[i]] != counts[keywords[j]] {
+			return counts[keywords[i]] > counts[keywords[j]]
+		}
+		return sortorder.NaturalLess(keywords[i], keywords[j])
+	})
+	if len(keywords) > maxKeywordLinks {
+		keywords = keywords[:maxKeywordLinks]
+	}
+	links := make([]templates.Link, 0, len(keywords))
+	for _, keyword := range keywords {
+		link := templates.Link{Title: keyword}
+		if keyword == opts.Keyword {
+			link.Current = true
+			link.Url = opts.WithKeyword("").QueryFull()
+		} else {
+			link.Url = opts.WithKeyword(keyword).QueryFull()
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// Route for lists of files
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	if gallery.IsExcludedPath(strings.TrimPrefix(r.URL.Path, urlPrefix)) {
+		fail404(w, r)
+		return
+	}
+	var (
+		parentUrl  string
+		title      string
+		err        error
+		contents   []os.FileInfo
+		folderPath string
+	)
+	folderPath = strings.TrimPrefix(r.URL.Path, urlPrefix)
+	fs, err := storage.Root.Open(folderPath)
+	if err != nil {
+		fail500(w, err, r)
+		return
+	}
+	defer fs.Close()
+	contents, err = fs.Readdir(-1)
+	if err != nil {
+		fail500(w, err, r)
+		return
+	}
+
+	folderInfo, _ := fs.Stat()
+	if folderPath != "/" && folderPath != "" {
+		title = filepath.Base(r.URL.Path)
+		parentUrl = path.Join(urlPrefix, folderPath, "..")
+	} else if config.Global.PublicHost != "" {
+		title = config.Global.PublicHost
+	}
+	opts := r.Context().Value(reqSettings).(config.RequestSettings)
+	// Links to other folders and to media do not keep the page
+	querystring := opts.WithPage(0).QueryString()
+	if parentUrl != "" { // parentUrl is empty when visiting the root folder
+		parentUrl += querystring
+	}
+
+	allChildren := listItems(folderPath, contents, opts, requestAccess(r))
+	addMetadata(allChildren)
+	children := filterItems(allChildren, opts)
+	for i := range children {
+		children[i].Url += itemSettings(opts, folderPath, children[i].Path).QueryString()
+	}
+	sortItems(r.Context(), children, opts)
+	meta := r.Context().Value(folderSettings).(config.FolderSettings)
+	folderUrl := gallery.EscapePath(path.Join(urlPrefix, folderPath))
+	page, pages := pageOf(opts.Page, len(children), opts.PageLimit())
+	pageChildren := pageItems(children, page, opts.PageLimit())
+	isTable := opts.Display == config.QueryDisplayTable
+	if isTable && !sortNeedsInfo(opts.Sort) {
+		addInfo(r.Context(), pageChildren)
+	}
+	pUrl, _ := url.Parse(folderPath)
+	crumbs := splitUrlToBreadCrumbs(pUrl, querystring)
+	w.Header().Set("Date", folderInfo.ModTime().UTC().Format(http.TimeFormat))
+	itemCount := ""
+	if folderPath != "/" && folderPath != "" && len(children) > 0 {
+		itemCount = fmt.Sprintf("%v ", len(children))
+	}
+
+	listTpl := templates.List{
+		Page: templates.Page{
+			Title:        title,
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+		},
+		BreadCrumbs:   crumbs,
+		ItemCount:     itemCount,
+		IsReversed:    opts.Order == config.QueryOrderDesc,
+		LinkOrderAsc:  opts.WithOrder(config.QueryOrderAsc).QueryFull(),
+		LinkOrderDesc: opts.WithOrder(config.QueryOrderDesc).QueryFull(),
+		SortLinks:     sortLinks(opts, len(meta.Sequence) > 0),
+		LinkFolders:   opts.WithDisplay(config.QueryDisplayShow).QueryFull(),
+		LinkRecursive: opts.WithDisplay(config.QueryDisplayRecursive).QueryFull(),
+		LinkTable:     opts.WithDisplay(config.QueryDisplayTable).QueryFull(),
+		LinkTimeline:  timelineUrl(folderUrl, 0, opts),
+		IsRecursive:   opts.Display == config.QueryDisplayRecursive,
+		IsTable:       isTable,
+		ParentUrl:     parentUrl,
+		Items:         pageChildren,
+		PageLinks:     pageLinks(folderUrl, page, pages, opts),
+		ClassLinks:    classLinks(allChildren, opts),
+		AlbumLinks:    albumLinks(folderPath, folderUrl, opts),
+		DownloadLinks: downloadLinks(folderUrl, meta),
+		ZipAction:     zipAction(folderUrl, meta),
+		LinkShare:     shareFormLink(r, folderUrl),
+		LinkReset:     resetLink(folderUrl, opts),
+		RatingLinks:   ratingLinks(allChildren, opts),
+		KeywordLinks:  keywordLinks(allChildren, opts),
+		Copyright:     config.Global.Copyright,
+	}
+	if isTable {
+		listTpl.ColumnLinks = columnLinks(opts)
+	}
+	if listTpl.ZipAction != "" {
+		markSelectable(pageChildren)
+	}
+	if page > 1 {
+		listTpl.LinkPrev = folderUrl + opts.WithPage(page-1).QueryString()
+	}
+	if page < pages {
+		listTpl.LinkNext = folderUrl + opts.WithPage(page+1).QueryString()
+	}
+
+	listTpl.Description = meta.Description
+	if meta.Copyright != "" {
+		listTpl.Copyright = meta.Copyright
+	}
+
+	if wantsJson(r) {
+		list := jsonList{
+			Title:       title,
+			Parent:      parentUrl,
+			BreadCrumbs: toJsonLinks(crumbs),
+			Items:       make([]jsonItem, 0, len(pageChildren)),
+			Page:        page,
+			Pages:       pages,
+			Total:       len(children),
+			Prev:        listTpl.LinkPrev,
+			Next:        listTpl.LinkNext,
+		}
+		public := meta.Public()
+		list.Settings = &public
+		for _, child := range pageChildren {
+			list.Items = append(list.Items, toJsonItem(r.Context(), child))
+		}
+		writeJson(w, r, list)
+		return
+	}
+
+	err = templates.Html.ExecuteTemplate(w, "layout", &listTpl)
+	if err != nil {
+		fail500(w, err, r)
+		return
+	}
+}
+
+// Maximum count of search results shown
+var maxSearchResults = 500
+
+// Gets the search term from a form (?search=term) or a link (?search/term)
+func searchTerm(r *http.Request) string {
+	if term := r.URL.Query().Get("search"); term != "" {
+		return term
+	}
+	q, _ := parseQuery(r.URL.RawQuery)
+	return q.Get("search")
+}
+
+// Route for finding media and folders by name in a folder and its subfolders
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	folderPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	if gallery.IsExcludedPath(folderPath) {
+		fail404(w, r)
+		return
+	}
+	if stat, err := storage.Root.Stat(folderPath); err != nil || !stat.IsDir() {
+		fail404(w, r)
+		return
+	}
+	term := strings.TrimSpace(searchTerm(r))
+	opts := r.Context().Value(reqSettings).(config.RequestSettings)
+	querystring := opts.WithPage(0).QueryString()
+	folderUrl := gallery.EscapePath(path.Join(urlPrefix, folderPath))
+
+	results := gallery.Search(term, folderPath, maxSearchResults)
+	items := make([]templates.ListItem, 0, len(results))
+	access := requestAccess(r)
+	for _, entry := range results {
+		if !access.AllowsPath(entry.Path, entry.IsDir) {
+			continue
+		}
+		item := newListItem("/"+entry.Path, entry.IsDir, entry.ModTime, entry.Size)
+		item.Url += querystring
+		item.Caption = path.Dir(entry.Path) // Where it was found
+		items = append(items, item)
+	}
+	zipUrl := zipAction(folderUrl, r.Context().Value(folderSettings).(config.FolderSettings))
+	if zipUrl != "" {
+		markSelectable(items)
+	}
+	pUrl, _ := url.Parse(folderPath)
+	crumbs := splitUrlToBreadCrumbs(pUrl, querystring)
+
+	if wantsJson(r) {
+		list := jsonList{
+			Title:       term,
+			Parent:      folderUrl + querystring,
+			BreadCrumbs: toJsonLinks(crumbs),
+			Items:       make([]jsonItem, 0, len(items)),
+			Page:        1,
+			Pages:       1,
+			Total:       len(items),
+		}
+		for _, item := range items {
+			list.Items = append(list.Items, toJsonItem(r.Context(), item))
+		}
+		writeJson(w, r, list)
+		return
+	}
+
+	err := templates.Html.ExecuteTemplate(w, "layout", &templates.List{
+		Page: templates.Page{
+			Title:        "search: " + term,
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+			SearchTerm:   term,
+		},
+		BreadCrumbs: crumbs,
+		ItemCount:   fmt.Sprintf("%v ", len(items)),
+		ParentUrl:   folderUrl + querystring,
+		Items:       items,
+		Copyright:   config.Global.Copyright,
+		ZipAction:   zipUrl,
+	})
+	if err != nil {
+		fail500(w, err, r)
+	}
+}
+
+// Prepares media from the search index as items with the time they were
+// taken. Alternates, Live Photo clips and media in folders the user cannot
+// see are left out.
+func indexItems(entries []gallery.IndexEntry, folderPath string, opts config.RequestSettings, access *config.FolderAccess) []templates.ListItem {
+	entries = slices.DeleteFunc(slices.Clone(entries), func(entry gallery.IndexEntry) bool {
+		return !access.AllowsPath(entry.Path, entry.IsDir)
+	})
+	folders := make(map[string][]string)
+	for _, entry := range entries {
+		folder := path.Dir("/" + entry.Path)
+		folders[folder] = append(folders[folder], entry.Name())
+	}
+	hiddenPaths := make(map[string]bool)
+	motions := make(map[string]string)
+	for folder, names := range folders {
+		_, livePairs, hidden := groupFolderFiles(folder, names)
+		for name := range hidden {
+			hiddenPaths[path.Join(folder, name)] = true
+		}
+		for still, clip := range livePairs {
+			motions[path.Join(folder, still)] = path.Join(folder, clip)
+		}
+	}
+	base := path.Clean("/" + folderPath)
+	items := make([]templates.ListItem, 0, len(entries))
+	for _, entry := range entries {
+		itemPath := "/" + entry.Path
+		if hiddenPaths[itemPath] || !isClassShown(gallery.GetMediaClass(itemPath), opts) {
+			continue
+		}
+		item := newListItem(itemPath, false, entry.ModTime, entry.Size)
+		if rel, err := filepath.Rel(base, itemPath); err == nil {
+			item.Id = gallery.EscapePath(rel)
+		}
+		if clip, ok := motions[itemPath]; ok {
+			item.Motion = fileUrl(gallery.EscapePath(filepath.Join(urlPrefix, clip)))
+			item.Class += " live"
+		}
+		item.Taken = entry.Taken().In(config.Global.TimeLocation)
+		items = append(items, item)
+	}
+	return items
+}
+
+// Groups items sorted by time in months and days
+func timelineMonths(items []templates.ListItem) []templates.TimelineMonth {
+	var months []templates.TimelineMonth
+	for _, item := range items {
+		monthId, dayId := item.Taken.Format("2006-01"), item.Taken.Format("2006-01-02")
+		if len(months) == 0 || months[len(months)-1].Id != monthId {
+			months = append(months, templates.TimelineMonth{
+				Id: monthId, Title: item.Taken.Format("January 2006")})
+		}
+		month := &months[len(months)-1]
+		if len(month.Days) == 0 || month.Days[len(month.Days)-1].Id != dayId {
+			month.Days = append(month.Days, templates.TimelineDay{
+				Id: dayId, Title: item.Taken.Format("Monday, 2 January")})
+		}
+		day := &month.Days[len(month.Days)-1]
+		day.Items = append(day.Items, item)
+	}
+	return months
+}
+
+// Link to a route of a folder keeping the settings
+func routeUrl(folderUrl, key, value string, opts config.RequestSettings) string {
+	query := opts.WithPage(0).QueryString()
+	switch {
+	case value != "":
+		query = strings.Replace(query, "?", "/", 1)
+		query = fmt.Sprintf("?%s/%s%s", key, url.QueryEscape(value), query)
+	case query == "":
+		query = "?" + key
+	default: // The key without a value must be the last
+		query += "/" + key
+	}
+	return folderUrl + query
+}
+
+// Link to the timeline of a folder. Without a year it shows the first one.
+func timelineUrl(folderUrl string, year int, opts config.RequestSettings) string {
+	if year > 0 {
+		return routeUrl(folderUrl, "timeline", strconv.Itoa(year), opts)
+	}
+	return routeUrl(folderUrl, "timeline", "", opts)
+}
+
+// Links to the pages with the media of a folder and its subfolders by date
+func dateLinks(folderUrl, current string, opts config.RequestSettings) []templates.Link {
+	return []templates.Link{
+		{Title: "timeline", Url: timelineUrl(folderUrl, 0, opts),
+			Current: current == "timeline"},
+		{Title: "on this day", Url: routeUrl(folderUrl, "onthisday", "", opts),
+			Current: current == "onthisday"},
+		{Title: "this week", Url: routeUrl(folderUrl, "onthisday", "week", opts),
+			Current: current == "week"},
+	}
+}
+
+// Route for the media of a folder and its subfolders by the time they were
+// taken. A year is shown at once, grouped in months and days.
+func timelineHandler(w http.ResponseWriter, r *http.Request) {
+	folderPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	if gallery.IsExcludedPath(folderPath) {
+		fail404(w, r)
+		return
+	}
+	if stat, err := storage.Root.Stat(folderPath); err != nil || !stat.IsDir() {
+		fail404(w, r)
+		return
+	}
+	opts := r.Context().Value(reqSettings).(config.RequestSettings)
+	q, _ := parseQuery(r.URL.RawQuery)
+	year, _ := strconv.Atoi(q.Get("timeline"))
+	querystring := opts.WithPage(0).QueryString()
+	folderUrl := gallery.EscapePath(path.Join(urlPrefix, folderPath))
+
+	items := indexItems(gallery.IndexFiles(folderPath), folderPath, opts, requestAccess(r))
+	isReversed := opts.Order == config.QueryOrderDesc
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Taken.Equal(items[j].Taken) {
+			return sortorder.NaturalLess(items[i].Path, items[j].Path)
+		}
+		return items[i].Taken.Before(items[j].Taken) != isReversed
+	})
+	var years []int
+	for _, item := range items {
+		if y := item.Taken.Year(); len(years) == 0 || years[len(years)-1] != y {
+			years = append(years, y)
+		}
+	}
+	current := slices.Index(years, year)
+	if current < 0 && len(years) > 0 {
+		current, year = 0, years[0]
+	}
+	var yearItems []templates.ListItem
+	for _, item := range items {
+		if item.Taken.Year() == year {
+			item.Url += opts.WithPage(0).WithDisplay(config.QueryDisplayShow).QueryString()
+			yearItems = append(yearItems, item)
+		}
+	}
+	months := timelineMonths(yearItems)
+
+	title := "timeline"
+	if year > 0 {
+		title += " " + strconv.Itoa(year)
+	}
+	pUrl, _ := url.Parse(folderPath)
+	crumbs := splitUrlToBreadCrumbs(pUrl, querystring)
+	page := templates.Timeline{
+		Page: templates.Page{
+			Title:        title,
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+			ParentUrl:    folderUrl + querystring,
+		},
+		BreadCrumbs: crumbs,
+		DateLinks:   dateLinks(folderUrl, "timeline", opts),
+		Months:      months,
+		LinkOrderAsc: timelineUrl(folderUrl, year,
+			*opts.WithSort(config.QuerySortDate).WithOrder(config.QueryOrderAsc)),
+		LinkOrderDesc: timelineUrl(folderUrl, year,
+			*opts.WithSort(config.QuerySortDate).WithOrder(config.QueryOrderDesc)),
+		ItemCount:  fmt.Sprintf("%v ", len(yearItems)),
+		Copyright:  config.Global.Copyright,
+		IsReversed: isReversed,
+	}
+	for i, y := range years {
+		page.YearLinks = append(page.YearLinks, templates.Link{
+			Title:   strconv.Itoa(y),
+			Url:     timelineUrl(folderUrl, y, opts),
+			Current: i == current,
+		})
+	}
+	if current > 0 {
+		page.LinkPrev = page.YearLinks[current-1].Url
+	}
+	if current >= 0 && current < len(years)-1 {
+		page.LinkNext = page.YearLinks[current+1].Url
+	}
+	for _, month := range months {
+		page.MonthLinks = append(page.MonthLinks, templates.Link{
+			Title: month.Title[:3],
+			Url:   "#" + month.Id,
+		})
+	}
+
+	if wantsJson(r) {
+		list := jsonList{
+			Title:       title,
+			Parent:      page.ParentUrl,
+			BreadCrumbs: toJsonLinks(crumbs),
+			Items:       make([]jsonItem, 0, len(yearItems)),
+			Page:        max(current+1, 1),
+			Pages:       max(len(years), 1),
+			Total:       len(yearItems),
+			Prev:        page.LinkPrev,
+			Next:        page.LinkNext,
+		}
+		for _, item := range yearItems {
+			list.Items = append(list.Items, toJsonItem(r.Context(), item))
+		}
+		writeJson(w, r, list)
+		return
+	}
+
+	if err := templates.Html.ExecuteTemplate(w, "timeline", &page); err != nil {
+		fail500(w, err, r)
+	}
+}
+
+// Route for media taken on this day, or this week, in earlier years
+func memoriesHandler(w http.ResponseWriter, r *http.Request) {
+	folderPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	if gallery.IsExcludedPath(folderPath) {
+		fail404(w, r)
+		return
+	}
+	if stat, err := storage.Root.Stat(folderPath); err != nil || !stat.IsDir() {
+		fail404(w, r)
+		return
+	}
+	opts := r.Context().Value(reqSettings).(config.RequestSettings)
+	q, _ := parseQuery(r.URL.RawQuery)
+	mode, days, title := "onthisday", 0, "on this day"
+	if q.Get("onthisday") == "week" {
+		mode, days, title = "week", gallery.MemoriesWeekDays, "this week"
+	}
+	querystring := opts.WithPage(0).QueryString()
+	folderUrl := gallery.EscapePath(path.Join(urlPrefix, folderPath))
+
+	today := time.Now().In(config.Global.TimeLocation)
+	items := indexItems(gallery.Memories(folderPath, today, days), folderPath, opts, requestAccess(r))
+	sort.Slice(items, func(i, j int) bool { // The latest first
+		if items[i].Taken.Equal(items[j].Taken) {
+			return sortorder.NaturalLess(items[i].Path, items[j].Path)
+		}
+		return items[i].Taken.After(items[j].Taken)
+	})
+	for i := range items {
+		items[i].Url += opts.WithPage(0).WithDisplay(config.QueryDisplayShow).QueryString()
+	}
+	months := timelineMonths(items)
+
+	pUrl, _ := url.Parse(folderPath)
+	crumbs := splitUrlToBreadCrumbs(pUrl, querystring)
+	page := templates.Timeline{
+		Page: templates.Page{
+			Title:        title,
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+			ParentUrl:    folderUrl + querystring,
+		},
+		BreadCrumbs: crumbs,
+		DateLinks:   dateLinks(folderUrl, mode, opts),
+		Months:      months,
+		ItemCount:   fmt.Sprintf("%v ", len(items)),
+		Copyright:   config.Global.Copyright,
+	}
+	for _, month := range months {
+		if year := month.Id[:4]; len(page.MonthLinks) == 0 ||
+			page.MonthLinks[len(page.MonthLinks)-1].Title != year {
+			page.MonthLinks = append(page.MonthLinks, templates.Link{
+				Title: year,
+				Url:   "#" + month.Id,
+			})
+		}
+	}
+
+	if wantsJson(r) {
+		list := jsonList{
+			Title:       title,
+			Parent:      page.ParentUrl,
+			BreadCrumbs: toJsonLinks(crumbs),
+			Items:       make([]jsonItem, 0, len(items)),
+			Page:        1,
+			Pages:       1,
+			Total:       len(items),
+		}
+		for _, item := range items {
+			list.Items = append(list.Items, toJsonItem(r.Context(), item))
+		}
+		writeJson(w, r, list)
+		return
+	}
+
+	if err := templates.Html.ExecuteTemplate(w, "timeline", &page); err != nil {
+		fail500(w, err, r)
+	}
+}
+
+// Link back to the default sort, order and display of a folder when the
+// user chose others
+func resetLink(folderUrl string, opts config.RequestSettings) string {
+	if opts.Preferences() == (config.RequestSettings{}) {
+		return ""
+	}
+	return folderUrl + "?reset"
+}
+
+// Link to an album of a folder keeping the settings
+func albumUrl(folderUrl, name string, opts config.RequestSettings) string {
+	return routeUrl(folderUrl, "album", name, opts)
+}
+
+// Albums of a folder, logging the errors in their files
+func folderAlbums(folderPath string) map[string]config.AlbumSettings {
+	albums, err := config.FolderAlbums(folderPath)
+	if err != nil {
+		logger.Printf("album error: %v\n", err)
+	}
+	return albums
+}
+
+// Links to the albums of a folder sorted by their titles
+func albumLinks(folderPath, folderUrl string, opts config.RequestSettings) []templates.Link {
+	albums := folderAlbums(folderPath)
+	links := make([]templates.Link, 0, len(albums))
+	for name, album := range albums {
+		links = append(links, templates.Link{
+			Title: cmp.Or(album.Title, name),
+			Url:   albumUrl(folderUrl, name, opts),
+		})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return sortorder.NaturalLess(
+			strings.ToLower(links[i].Title), strings.ToLower(links[j].Title))
+	})
+	return links
+}
+
+// Settings for the link to media viewed in an album of a folder
+func albumItemSettings(opts config.RequestSettings, folderPath, name, itemPath string) *config.RequestSettings {
+	return opts.WithPage(0).WithDisplay(config.QueryDisplayShow).
+		WithUp(levelsUp(folderPath, itemPath)).WithAlbum(name)
+}
+
+// Media of an album in its order, not filtered by metadata
+func albumItems(folderPath string, album config.AlbumSettings, opts config.RequestSettings, access *config.FolderAccess) []templates.ListItem {
+	return indexItems(gallery.AlbumFiles(folderPath, album.Items), folderPath, opts, access)
+}
+
+// Route for the media of an album of a folder. Items link to media pages
+// which go through the album in its order.
+func albumHandler(w http.ResponseWriter, r *http.Request) {
+	folderPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	if gallery.IsExcludedPath(folderPath) {
+		fail404(w, r)
+		return
+	}
+	if stat, err := storage.Root.Stat(folderPath); err != nil || !stat.IsDir() {
+		fail404(w, r)
+		return
+	}
+	opts := r.Context().Value(reqSettings).(config.RequestSettings)
+	q, _ := parseQuery(r.URL.RawQuery)
+	name := q.Get("album")
+	album, ok := folderAlbums(folderPath)[name]
+	if !ok {
+		fail404(w, r)
+		return
+	}
+	querystring := opts.WithPage(0).QueryString()
+	folderUrl := gallery.EscapePath(path.Join(urlPrefix, folderPath))
+
+	items := albumItems(folderPath, album, opts, requestAccess(r))
+	addMetadata(items)
+	for i := range items {
+		items[i].Url += albumItemSettings(opts, folderPath, name, items[i].Path).QueryString()
+	}
+	title := cmp.Or(album.Title, name)
+	pUrl, _ := url.Parse(folderPath)
+	crumbs := splitUrlToBreadCrumbs(pUrl, querystring)
+
+	if wantsJson(r) {
+		list := jsonList{
+			Title:       title,
+			Parent:      folderUrl + querystring,
+			BreadCrumbs: toJsonLinks(crumbs),
+			Items:       make([]jsonItem, 0, len(items)),
+			Page:        1,
+			Pages:       1,
+			Total:       len(items),
+		}
+		for _, item := range items {
+			list.Items = append(list.Items, toJsonItem(r.Context(), item))
+		}
+		writeJson(w, r, list)
+		return
+	}
+
+	copyright := config.Global.Copyright
+	if meta := r.Context().Value(folderSettings).(config.FolderSettings); meta.Copyright != "" {
+		copyright = meta.Copyright
+	}
+	err := templates.Html.ExecuteTemplate(w, "layout", &templates.List{
+		Page: templates.Page{
+			Title:        title,
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+		},
+		BreadCrumbs: crumbs,
+		ItemCount:   fmt.Sprintf("%v ", len(items)),
+		ParentUrl:   folderUrl + querystring,
+		Items:       items,
+		Description: album.Description,
+		Copyright:   copyright,
+		IsAlbum:     true,
+	})
+	if err != nil {
+		fail500(w, err, r)
+	}
+}
+
+// Media files of a folder in a ZIP archive, with those of subfolders
+// which can be downloaded and the user can see when recursive
+func zipEntries(folderPath string, recursive bool, access *config.FolderAccess) (entries []gallery.ZipEntry) {
+	start := strings.Trim(path.Clean("/"+folderPath), "/")
+	ignored := gallery.NewIgnoreWalker()
+	err := afero.Walk(storage.Root, "/"+start,
+		func(walkPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath := strings.TrimPrefix(filepath.ToSlash(walkPath), "/")
+			if relPath == start {
+				return nil
+			}
+			if ignored.Excludes(relPath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if !recursive || !access.Allows(relPath) ||
+					!folderSettingsOf(relPath).DownloadAllowed() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if gallery.IsValidMedia(relPath) {
+				entries = append(entries, gallery.ZipEntry{
+					Name:    strings.TrimPrefix(relPath, start+"/"),
+					Path:    relPath,
+					Size:    info.Size(),
+					ModTime: info.ModTime(),
+				})
+			}
+			return nil
+		})
+	if err != nil {
+		logger.Printf("zip error: %v\n", err)
+	}
+	return
+}
+
+// Largest ZIP archive of a folder in bytes, zero when disabled
+func zipMaxSize() int64 {
+	return int64(max(0, config.Global.ZipMaxSize)) << 20
+}
+
+// Links to download a folder as a ZIP archive when its settings allow it
+func downloadLinks(folderUrl string, meta config.FolderSettings) []templates.Link {
+	if zipMaxSize() == 0 || !meta.DownloadAllowed() {
+		return nil
 	}
-	if err := templates.Html.ExecuteTemplate(w, "table", &page); err != nil {
-		fail500(w, err, r)
+	return []templates.Link{
+		{Title: "folder", Url: folderUrl + "?zip"},
+		{Title: "all", Url: folderUrl + "?zip/all"},
 	}
 }
 
-// Route for lists of files
-func listHandler(w http.ResponseWriter, r *http.Request) {
-	if gallery.ContainsDotFile(r.URL.Path) {
+// Form action to download the items ticked in the list of a folder
+func zipAction(folderUrl string, meta config.FolderSettings) string {
+	if zipMaxSize() == 0 || !meta.DownloadAllowed() {
+		return ""
+	}
+	return folderUrl + "?zip"
+}
+
+// Route for the media of a folder (?zip) or of it and its subfolders
+// (?zip/all) as a ZIP archive streamed from the files. A POST with path
+// fields downloads the media and folders ticked in its list instead.
+func zipHandler(w http.ResponseWriter, r *http.Request) {
+	folderPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	if gallery.IsExcludedPath(folderPath) {
 		fail404(w, r)
 		return
 	}
-	var (
-		parentUrl  string
-		title      string
-		err        error
-		contents   []os.FileInfo
-		folderPath string
-	)
-	folderPath = strings.TrimPrefix(r.URL.Path, urlPrefix)
-	fs, err := storage.Root.Open(folderPath)
-	if err != nil {
-		fail500(w, err, r)
+	if stat, err := storage.Root.Stat(folderPath); err != nil || !stat.IsDir() {
+		fail404(w, r)
 		return
 	}
-	defer fs.Close()
-	contents, err = fs.Readdir(-1)
-	if err != nil {
-		fail500(w, err, r)
+	meta := r.Context().Value(folderSettings).(config.FolderSettings)
+	if zipMaxSize() == 0 || !meta.DownloadAllowed() {
+		http.Error(w, "download of this folder is disabled", http.StatusForbidden)
+		return
+	}
+	var entries []gallery.ZipEntry
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		entries, err = selectedZipEntries(folderPath, r.PostForm["path"], requestAccess(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		q, _ := parseQuery(r.URL.RawQuery)
+		entries = zipEntries(folderPath, q.Get("zip") == "all", requestAccess(r))
+	}
+	size := gallery.ZipSize(entries)
+	if size > zipMaxSize() {
+		http.Error(w, fmt.Sprintf("folder is too large to download (%d MiB)", size>>20),
+			http.StatusForbidden)
 		return
 	}
 
-	folderInfo, _ := fs.Stat()
-	if folderPath != "/" && folderPath != "" {
-		title = filepath.Base(r.URL.Path)
-		parentUrl = path.Join(urlPrefix, folderPath, "..")
-	} else if config.Global.PublicHost != "" {
-		title = config.Global.PublicHost
+	name := path.Base(path.Clean("/" + folderPath))
+	if name == "/" {
+		name = cmp.Or(config.Global.PublicHost, "gallery")
 	}
-	opts := r.Context().Value(reqSettings).(config.RequestSettings)
-	querystring := opts.QueryString()
-	if parentUrl != "" { // parentUrl is empty when visiting the root folder
-		parentUrl += querystring
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		return
 	}
+	if err := gallery.WriteZip(w, entries); err != nil {
+		// Too late for an error page, the client gets a short archive
+		logger.Printf("zip error: %s: %v\n", folderPath, err)
+	}
+}
 
-	children := make([]templates.ListItem, 0, len(contents))
-	for _, child := range contents {
-		if gallery.ContainsDotFile(child.Name()) {
-			continue
+// Maximum count of paths ticked for one download
+var maxSelectedPaths = 10000
+
+// Media ticked in the list of a folder for a ZIP archive, named relative
+// to the folder. Folders add their media and that of their subfolders.
+// Paths are relative to the root and must be media or folders which can be
+// downloaded in the folder.
+func selectedZipEntries(folderPath string, paths []string, access *config.FolderAccess) ([]gallery.ZipEntry, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no media selected")
+	}
+	if len(paths) > maxSelectedPaths {
+		return nil, fmt.Errorf("more than %d items selected", maxSelectedPaths)
+	}
+	start := strings.Trim(path.Clean("/"+folderPath), "/")
+	var (
+		entries []gallery.ZipEntry
+		added   = make(map[string]bool)
+	)
+	add := func(entry gallery.ZipEntry) {
+		if !added[entry.Path] {
+			added[entry.Path] = true
+			entry.Name = strings.TrimPrefix(entry.Path, start+"/")
+			entries = append(entries, entry)
 		}
-		mediaClass := gallery.GetMediaClass(child.Name())
-		if !child.IsDir() && mediaClass == "" {
-			continue
+	}
+	for _, selected := range paths {
+		relPath := sanitizePath(selected)
+		if relPath == "." || gallery.ContainsDotFile(relPath) ||
+			(start != "" && !strings.HasPrefix(relPath, start+"/")) {
+			return nil, fmt.Errorf("invalid path: %s", selected)
 		}
-		childPath := filepath.Join(urlPrefix, folderPath, child.Name())
-		childPath = gallery.EscapePath(childPath)
-		thumb := urlPrefix + "/?static/ui.svg#iconFolder"
-		class := "folder"
-		if !child.IsDir() {
-			thumb = gallery.EscapePath(filepath.Join(urlPrefix, folderPath, child.Name())) + "?thumb"
-			class = string(mediaClass)
-			if config.Global.Ffmpeg == "" {
-				class += " nothumb"
+		stat, err := storage.Root.Stat("/" + relPath)
+		if err != nil || gallery.IsExcluded(relPath, stat.IsDir()) ||
+			!access.AllowsPath(relPath, stat.IsDir()) {
+			return nil, fmt.Errorf("not found: %s", selected)
+		}
+		if stat.IsDir() {
+			if !folderSettingsOf(relPath).DownloadAllowed() {
+				return nil, fmt.Errorf("download is disabled: %s", selected)
+			}
+			for _, entry := range zipEntries(relPath, true, access) {
+				add(entry)
 			}
+			continue
 		}
-		children = append(children, templates.ListItem{
-			Id:      gallery.EscapePath(child.Name()),
-			ModTime: child.ModTime(),
-			Url:     childPath + querystring,
-			Name:    child.Name(),
-			Thumb:   thumb,
-			Class:   class,
-			W:       config.Global.ThumbWidth,
-			H:       config.Global.ThumbHeight,
-		})
+		if !gallery.IsValidMedia(relPath) {
+			return nil, fmt.Errorf("not media: %s", selected)
+		}
+		if !folderSettingsOf(path.Dir(relPath)).DownloadAllowed() {
+			return nil, fmt.Errorf("download is disabled: %s", selected)
+		}
+		add(gallery.ZipEntry{Path: relPath, Size: stat.Size(), ModTime: stat.ModTime()})
 	}
-	sort.Slice(children,
-		itemSorter(children, opts.Sort, opts.Order == config.QueryOrderDesc))
-	pUrl, _ := url.Parse(folderPath)
-	crumbs := splitUrlToBreadCrumbs(pUrl, querystring)
-	w.Header().Set("Date", folderInfo.ModTime().UTC().Format(http.TimeFormat))
-	itemCount := ""
-	if folderPath != "/" && folderPath != "" && len(children) > 0 {
-		itemCount = fmt.Sprintf("%v ", len(children))
+	return entries, nil
+}
+
+// Marks the items which can be ticked for a ZIP download of the selection
+func markSelectable(items []templates.ListItem) {
+	for i := range items {
+		folder := items[i].Path
+		if !items[i].IsFolder() {
+			folder = path.Dir(folder)
+		}
+		items[i].Selectable = folderSettingsOf(folder).DownloadAllowed()
 	}
+}
 
-	listTpl := templates.List{
-		Page: templates.Page{
-			Title:        title,
-			Prefix:       urlPrefix,
-			AppVersion:   BuildVersion,
-			AppBuildTime: BuildTimestamp,
-		},
-		BreadCrumbs:    crumbs,
-		ItemCount:      itemCount,
-		IsSortedByName: opts.Sort == config.QuerySortName,
-		IsReversed:     opts.Order == config.QueryOrderDesc,
-		LinkOrderAsc:   opts.WithOrder(config.QueryOrderAsc).QueryFull(),
-		LinkOrderDesc:  opts.WithOrder(config.QueryOrderDesc).QueryFull(),
-		LinkSortName:   opts.WithSort(config.QuerySortName).QueryFull(),
-		LinkSortDate:   opts.WithSort(config.QuerySortDate).QueryFull(),
-		ParentUrl:      parentUrl,
-		Items:          children,
-		Copyright:      config.Global.Copyright,
-	}
-
-	metaCtx := r.Context().Value(folderSettings)
-	if metaCtx != nil {
-		meta := metaCtx.(config.FolderSettings)
-		listTpl.Description = meta.Description
-		listTpl.Copyright = meta.Copyright
+// Finds the page to show (from 1) and the count of pages
+func pageOf(page, total, limit int) (int, int) {
+	if limit <= 0 || total == 0 {
+		return 1, 1
 	}
+	pages := (total + limit - 1) / limit
+	return min(max(page, 1), pages), pages
+}
 
-	err = templates.Html.ExecuteTemplate(w, "layout", &listTpl)
-	if err != nil {
-		fail500(w, err, r)
-		return
+// Items on a page, all of them without a limit
+func pageItems(items []templates.ListItem, page, limit int) []templates.ListItem {
+	if limit <= 0 {
+		return items
+	}
+	start := min((page-1)*limit, len(items))
+	return items[start:min(start+limit, len(items))]
+}
+
+// Pages shown around the current one in page links
+var pageLinksAround = 2
+
+// Links to the pages of a list. Long ranges of pages are left out
+// and marked by links without an URL.
+func pageLinks(baseUrl string, page, pages int, opts config.RequestSettings) []templates.Link {
+	if pages < 2 {
+		return nil
+	}
+	links := make([]templates.Link, 0, 2*pageLinksAround+5)
+	for p := 1; p <= pages; p++ {
+		if p > 1 && p < pages && (p < page-pageLinksAround || p > page+pageLinksAround) {
+			if links[len(links)-1].Url != "" {
+				links = append(links, templates.Link{Title: "…"})
+			}
+			continue
+		}
+		links = append(links, templates.Link{
+			Title:   strconv.Itoa(p),
+			Url:     baseUrl + opts.WithPage(p).QueryString(),
+			Current: p == page,
+		})
 	}
+	return links
 }
 
 type LessFunc func(i, j int) bool
@@ -379,31 +1613,224 @@ func reverse(less LessFunc) LessFunc {
 	return func(i, j int) bool { return !less(i, j) }
 }
 
-func itemSorter(li []templates.ListItem, field config.QTypeSort, rev bool) LessFunc {
+func itemSorter(li []templates.ListItem, opts config.RequestSettings) LessFunc {
+	byName := func(first, second int) bool {
+		return sortorder.NaturalLess(
+			strings.ToLower(li[first].Name),
+			strings.ToLower(li[second].Name))
+	}
+	// Compares by a value and by name when the values are equal
+	by := func(value func(item templates.ListItem) int64) LessFunc {
+		return func(i, j int) bool {
+			if a, b := value(li[i]), value(li[j]); a != b {
+				return a < b
+			}
+			return byName(i, j)
+		}
+	}
 	var sorter LessFunc
-	switch field {
+	switch opts.Sort {
 	case config.QuerySortDate:
 		sorter = func(i, j int) bool {
 			return li[i].ModTime.Before(li[j].ModTime)
 		}
 	case config.QuerySortName:
-		sorter = func(first, second int) bool {
-			return sortorder.NaturalLess(
-				strings.ToLower(li[first].Name),
-				strings.ToLower(li[second].Name))
+		sorter = byName
+	case config.QuerySortSize:
+		sorter = by(func(item templates.ListItem) int64 { return item.Size })
+	case config.QuerySortPixels:
+		sorter = by(func(item templates.ListItem) int64 {
+			return int64(item.Width) * int64(item.Height)
+		})
+	case config.QuerySortDuration:
+		sorter = by(func(item templates.ListItem) int64 { return int64(item.Duration) })
+	case config.QuerySortShuffle:
+		sorter = by(func(item templates.ListItem) int64 {
+			return shuffleKey(opts.Seed, item.Path)
+		})
+	case config.QuerySortCustom:
+		// Unlisted items follow by name, in either order
+		desc := opts.Order == config.QueryOrderDesc
+		return func(i, j int) bool {
+			a, b := li[i].Position, li[j].Position
+			switch {
+			case a == b:
+				return byName(i, j)
+			case a == 0 || b == 0:
+				return b == 0
+			case desc:
+				return a > b
+			}
+			return a < b
+		}
+	case config.QuerySortType:
+		sorter = func(i, j int) bool {
+			a, b := li[i].BaseClass(), li[j].BaseClass()
+			if a == b {
+				a = strings.ToLower(filepath.Ext(li[i].Name))
+				b = strings.ToLower(filepath.Ext(li[j].Name))
+			}
+			if a != b {
+				return a < b
+			}
+			return byName(i, j)
 		}
 	default:
 		sorter = func(_, _ int) bool { return true }
 	}
-	if rev {
+	if opts.Order == config.QueryOrderDesc {
 		return reverse(sorter)
 	}
 	return sorter
 }
 
+// Position of an item in the order shuffled with seed
+func shuffleKey(seed int64, itemPath string) int64 {
+	hash := fnv.New64a()
+	_ = binary.Write(hash, binary.LittleEndian, seed)
+	_, _ = hash.Write([]byte(itemPath))
+	return int64(hash.Sum64() >> 1)
+}
+
+// Checks if sorting needs the dimensions or duration of media
+func sortNeedsInfo(sort config.QTypeSort) bool {
+	return sort == config.QuerySortPixels || sort == config.QuerySortDuration
+}
+
+// Reads the dimensions and duration of media items
+func addInfo(ctx context.Context, items []templates.ListItem) {
+	for i := range items {
+		if items[i].IsFolder() {
+			continue
+		}
+		info := gallery.ReadInfo(ctx, items[i].Path)
+		items[i].Width = info.Width
+		items[i].Height = info.Height
+		items[i].Duration = info.Duration
+	}
+}
+
+// Reads the custom positions and pins of items from their folder settings
+func addArrangement(items []templates.ListItem) {
+	folders := make(map[string]config.FolderSettings)
+	for i := range items {
+		item := &items[i]
+		folderPath := path.Dir(item.Path)
+		settings, ok := folders[folderPath]
+		if !ok {
+			settings = folderSettingsOf(folderPath)
+			folders[folderPath] = settings
+		}
+		item.Position = slices.Index(settings.Sequence, item.Name) + 1
+		item.Pinned = slices.Contains(settings.Pinned, item.Name)
+	}
+}
+
+// Sorts items with the sort and order of the request.
+// Pinned items come first, in the same order.
+func sortItems(ctx context.Context, items []templates.ListItem, opts config.RequestSettings) {
+	if sortNeedsInfo(opts.Sort) {
+		addInfo(ctx, items)
+	}
+	addArrangement(items)
+	sort.Slice(items, itemSorter(items, opts))
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Pinned && !items[j].Pinned
+	})
+}
+
+// Seed for a new shuffled order
+func newSeed() int64 {
+	return rand.Int64N(math.MaxInt32) + 1
+}
+
+// Links for sorting by each key, the custom sort when the folder has one
+func sortLinks(opts config.RequestSettings, custom bool) []templates.Link {
+	sorts := []struct {
+		sort  config.QTypeSort
+		title string
+	}{
+		{config.QuerySortName, "name"},
+		{config.QuerySortDate, "date"},
+		{config.QuerySortSize, "size"},
+		{config.QuerySortType, "type"},
+		{config.QuerySortPixels, "pixels"},
+		{config.QuerySortDuration, "length"},
+		{config.QuerySortShuffle, "shuffle"},
+		{config.QuerySortCustom, "custom"},
+	}
+	links := make([]templates.Link, 0, len(sorts))
+	for _, s := range sorts {
+		if s.sort == config.QuerySortDuration && config.Global.Ffmpeg == "" {
+			continue // Durations are known only from ffmpeg
+		}
+		if s.sort == config.QuerySortCustom && !custom {
+			continue
+		}
+		sortOpts := opts.WithSort(s.sort)
+		if s.sort == config.QuerySortShuffle {
+			sortOpts.Seed = newSeed() // Shuffle again on every click
+		}
+		if s.sort == config.QuerySortCustom {
+			sortOpts.Order = config.QueryOrderAsc // The sequence from its start
+		}
+		links = append(links, templates.Link{
+			Title:   s.title,
+			Url:     sortOpts.QueryFull(),
+			Current: opts.Sort == s.sort,
+		})
+	}
+	return links
+}
+
+// Links in the headers of the table of a folder sorting by their column.
+// The current one changes the order.
+func columnLinks(opts config.RequestSettings) []templates.Link {
+	columns := []struct {
+		sort  config.QTypeSort
+		title string
+	}{
+		{config.QuerySortName, "name"},
+		{config.QuerySortType, "type"},
+		{config.QuerySortSize, "size"},
+		{config.QuerySortDate, "modified"},
+		{config.QuerySortPixels, "dimensions"},
+		{config.QuerySortDuration, "duration"},
+	}
+	links := make([]templates.Link, 0, len(columns))
+	for _, column := range columns {
+		sortOpts := opts.WithSort(column.sort)
+		if opts.Sort == column.sort {
+			sortOpts.Order = config.QueryOrderAsc
+			if opts.Order == config.QueryOrderAsc {
+				sortOpts.Order = config.QueryOrderDesc
+			}
+		}
+		links = append(links, templates.Link{
+			Title:   column.title,
+			Url:     sortOpts.QueryFull(),
+			Current: opts.Sort == column.sort,
+		})
+	}
+	return links
+}
+
+// Checks if a file (by its url) is folded into a list item
+func isPartOf(item templates.ListItem, fileLink string) bool {
+	if item.Motion == fileLink {
+		return true
+	}
+	for _, alternate := range item.Alternates {
+		if alternate.Url == fileLink {
+			return true
+		}
+	}
+	return false
+}
+
 // Serve html containers for media
 func viewHandler(w http.ResponseWriter, r *http.Request) {
-	if gallery.ContainsDotFile(r.URL.Path) {
+	if gallery.IsExcludedPath(strings.TrimPrefix(r.URL.Path, urlPrefix)) {
 		fail404(w, r)
 		return
 	}
@@ -425,90 +1852,173 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the parent folder
-	parentUrl := path.Join(urlPrefix, fullPath, "..")
-	folderPath := strings.TrimPrefix(parentUrl, urlPrefix)
-	fs, err := storage.Root.Open(folderPath)
-	if err != nil {
-		fail500(w, err, r)
+	opts := r.Context().Value(reqSettings).(config.RequestSettings)
+	opts.Page = 0 // Media is paged through the whole folder
+	albumName := opts.Album
+	opts.Album = ""
+
+	// Get the parent folder, or the listed one above it in recursive display
+	// or the one with the album
+	folderPath := path.Dir(path.Clean("/" + fullPath))
+	if opts.Display == config.QueryDisplayRecursive || albumName != "" {
+		folderPath = folderUp(folderPath, opts.Up)
+	}
+	access := requestAccess(r)
+	if !access.Allows(folderPath) && !access.Shared(fullPath) {
+		fail404(w, r)
 		return
 	}
-	defer fs.Close()
-	contents, err := fs.Readdir(-1)
-	if err != nil {
-		fail500(w, err, r)
-		return
+	album, inAlbum := config.AlbumSettings{}, false
+	if albumName != "" {
+		album, inAlbum = folderAlbums(folderPath)[albumName]
 	}
-	opts := r.Context().Value(reqSettings).(config.RequestSettings)
+	opts.Up = 0
 	querystring := opts.QueryString()
-
+	parentUrl := path.Join(urlPrefix, folderPath)
 	escCurrentMediaPath := gallery.EscapePath(filepath.Join(urlPrefix, fullPath))
-	currentMediaPath, _ := url.PathUnescape(escCurrentMediaPath)
-
-	totalItems := 0
-
-	// Collect all children of parent folder
-	children := make([]templates.ListItem, 0, len(contents))
-	for _, child := range contents {
-		if gallery.ContainsDotFile(child.Name()) {
-			continue
+	currentChild := templates.ListItem{
+		Path: fullPath, Id: filepath.Base(escCurrentMediaPath)}
+	linkSettings := func(itemPath string) *config.RequestSettings {
+		return itemSettings(opts, folderPath, itemPath)
+	}
+
+	var children []templates.ListItem
+	if inAlbum {
+		// Media of the album in its order
+		children = albumItems(folderPath, album, opts, access)
+		for _, child := range children {
+			if child.Url == escCurrentMediaPath {
+				currentChild = child
+			}
 		}
-		// Look only for media items
-		if mediaClass := gallery.GetMediaClass(child.Name()); child.IsDir() || (!child.IsDir() && mediaClass == "") {
-			continue
+		linkSettings = func(itemPath string) *config.RequestSettings {
+			return albumItemSettings(opts, folderPath, albumName, itemPath)
 		}
-		childPath := filepath.Join(urlPrefix, folderPath, child.Name())
-		childPath = gallery.EscapePath(childPath)
-
-		// Get total count of items in parent folder
-		totalItems += 1
-		unescapedPath, _ := url.PathUnescape(childPath)
-		children = append(children, templates.ListItem{
-			ModTime: child.ModTime(),
-			Url:     unescapedPath,
-			Name:    child.Name(),
-		})
+	} else {
+		if albumName != "" { // The album is gone, go through the folder
+			folderPath = path.Dir(path.Clean("/" + fullPath))
+			parentUrl = path.Join(urlPrefix, folderPath)
+		}
+		contents, err := readFolder(folderPath)
+		if err != nil {
+			fail500(w, err, r)
+			return
+		}
+		// Collect all media children of parent folder
+		children = make([]templates.ListItem, 0, len(contents))
+		for _, child := range listItems(folderPath, contents, opts, access) {
+			// Shared media is alone when its folder is not shared
+			if child.IsFolder() || !access.AllowsPath(child.Path, false) {
+				continue
+			}
+			// Clips of Live Photos and alternates are shown with their main item
+			if isPartOf(child, fileUrl(escCurrentMediaPath)) {
+				http.Redirect(w, r, child.Url+linkSettings(child.Path).QueryString(),
+					http.StatusFound)
+				return
+			}
+			if child.Url == escCurrentMediaPath {
+				currentChild = child
+			}
+			children = append(children, child)
+		}
+		// Metadata of all items is needed only to filter them by it
+		if hasMetadataFilter(opts) {
+			addMetadata(children)
+		}
+		children = filterItems(children, opts)
+		sortItems(r.Context(), children, opts)
 	}
-
-	sort.Slice(children,
-		itemSorter(children, opts.Sort, opts.Order == config.QueryOrderDesc))
+	currentItem := []templates.ListItem{currentChild}
+	addMetadata(currentItem)
+	currentChild = currentItem[0]
+	totalItems := len(children)
 
 	// Get previous and next items according to the current sort order
 	var lastChild, nextChild templates.ListItem
+	parentPage := 1 // The page of the folder with the current item
 	for i, child := range children {
-		if child.Url == currentMediaPath {
+		if child.Url == escCurrentMediaPath {
+			if limit := opts.PageLimit(); limit > 0 {
+				parentPage = i/limit + 1
+			}
 			if i == 0 {
 				// No previous child if we are the first one
 				lastChild = templates.ListItem{}
 			} else {
-				lastChild.Url += querystring
+				lastChild.Url += linkSettings(lastChild.Path).QueryString()
 			}
 			if totalItems > i+1 {
 				nextChild = children[i+1]
-				nextChild.Url += querystring
+				nextChild.Url += linkSettings(nextChild.Path).QueryString()
 			}
 			break
 		}
 		lastChild = child
 	}
 
+	// Live Photos play their clip, motion photos the video embedded in them
+	motionPath := currentChild.Motion
+	if motionPath == "" && mediaType == gallery.MediaImage {
+		if _, err := gallery.NewMotionPhoto(fullPath, motionCachePath(fullPath)); err == nil {
+			motionPath = escCurrentMediaPath + "?motion"
+		}
+	}
+	if motionPath != "" {
+		templateName = "view_motion"
+	}
+
+	parentLink := parentUrl + opts.WithPage(parentPage).QueryString()
 	var parentName string
 	if parentUrl == "/" {
 		parentName = "../"
 	} else {
 		parentName = "../" + filepath.Base(parentUrl)
 	}
-	err = templates.Html.ExecuteTemplate(w, templateName, &templates.ViewPage{
+	if inAlbum {
+		parentLink = albumUrl(gallery.EscapePath(parentUrl), albumName, opts)
+		parentName = "../" + cmp.Or(album.Title, albumName)
+	}
+
+	if wantsJson(r) {
+		pUrl, _ := url.Parse(folderPath)
+		view := jsonView{
+			Item:        toJsonItem(r.Context(), currentChild),
+			Parent:      parentLink,
+			Prev:        lastChild.Url,
+			Next:        nextChild.Url,
+			BreadCrumbs: toJsonLinks(splitUrlToBreadCrumbs(pUrl, querystring)),
+		}
+		meta := r.Context().Value(folderSettings).(config.FolderSettings)
+		public := meta.Public()
+		view.Settings = &public
+		writeJson(w, r, view)
+		return
+	}
+
+	copyright := config.Global.Copyright
+	if meta := r.Context().Value(folderSettings).(config.FolderSettings); meta.Copyright != "" {
+		copyright = meta.Copyright
+	}
+	err := templates.Html.ExecuteTemplate(w, templateName, &templates.ViewPage{
 		Page: templates.Page{
 			Title:      escCurrentMediaPath,
 			Prefix:     urlPrefix,
 			LinkPrev:   string(lastChild.Url),
 			LinkNext:   string(nextChild.Url),
-			ParentUrl:  parentUrl + querystring + "#" + filepath.Base(escCurrentMediaPath),
+			ParentUrl:  parentLink + "#" + currentChild.Id,
 			ParentName: parentName,
 		},
-		MediaPath: fmt.Sprintf("%s?%s/%s",
-			escCurrentMediaPath, config.QKeyDisplay, config.QueryDisplayFile),
+		MediaPath:  fileUrl(escCurrentMediaPath),
+		MotionPath: motionPath,
+		Alternates: currentChild.Alternates,
+		Heading:    currentChild.Title,
+		Caption:    currentChild.Caption,
+		Alt:        currentChild.Alt,
+		Rating:     currentChild.Rating,
+		Keywords:   viewKeywordLinks(currentChild.Keywords, parentUrl, opts),
+		Copyright:  copyright,
+		LinkShare:  shareFormLink(r, escCurrentMediaPath),
 	})
 	if err != nil {
 		fail500(w, err, r)
@@ -516,9 +2026,21 @@ func viewHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Links from keywords of media to its folder filtered by them
+func viewKeywordLinks(keywords []string, parentUrl string, opts config.RequestSettings) []templates.Link {
+	links := make([]templates.Link, 0, len(keywords))
+	for _, keyword := range keywords {
+		links = append(links, templates.Link{
+			Title: keyword,
+			Url:   parentUrl + opts.WithKeyword(keyword).QueryFull(),
+		})
+	}
+	return links
+}
+
 // Route to serve actual files
 func fileHandler(w http.ResponseWriter, r *http.Request) {
-	if gallery.ContainsDotFile(r.URL.Path) {
+	if gallery.IsExcludedPath(strings.TrimPrefix(r.URL.Path, urlPrefix)) {
 		fail404(w, r)
 		return
 	}
@@ -526,6 +2048,10 @@ func fileHandler(w http.ResponseWriter, r *http.Request) {
 	media, err := gallery.NewMedia(fullPath)
 	if err != nil {
 		if errors.Is(err, gallery.ErrNotValid) {
+			if isAlternate(fullPath) {
+				alternateHandler(w, r)
+				return
+			}
 			// Hide invalid media as non-existing
 			fail404(w, r)
 			return
@@ -547,6 +2073,46 @@ func fileHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, fullPath, media.FileModTime(), contents)
 }
 
+// Checks if a file is grouped as an alternate of a media file
+func isAlternate(fullPath string) bool {
+	folderPath := path.Dir(fullPath)
+	fs, err := storage.Root.Open(folderPath)
+	if err != nil {
+		return false
+	}
+	defer fs.Close()
+	names, err := fs.Readdirnames(-1)
+	if err != nil {
+		return false
+	}
+	name := path.Base(fullPath)
+	for _, alternates := range gallery.GroupFiles(names, groupExtensions(folderPath)) {
+		if slices.Contains(alternates, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serves files which are not media (like sidecars) as downloads
+func alternateHandler(w http.ResponseWriter, r *http.Request) {
+	fullPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	contents, err := storage.Root.Open(fullPath)
+	if err != nil {
+		fail404(w, r)
+		return
+	}
+	defer contents.Close()
+	info, err := contents.Stat()
+	if err != nil || info.IsDir() {
+		fail404(w, r)
+		return
+	}
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), contents)
+}
+
 // Delivers file contents for static resources
 func staticHandler(resFile string, w http.ResponseWriter, r *http.Request) {
 	staticFile, err := storage.InternalHttp.Open(resFile)
@@ -561,6 +2127,7 @@ func staticHandler(resFile string, w http.ResponseWriter, r *http.Request) {
 // Route for RSS/Atom feed
 func feedHandler(feedType feed, w http.ResponseWriter, r *http.Request) {
 	loc, _ := time.LoadLocation("UTC")
+	opts := r.Context().Value(reqSettings).(config.RequestSettings)
 
 	var formatTime func(time.Time) string
 	if feedType == feedRss {
@@ -582,20 +2149,29 @@ func feedHandler(feedType feed, w http.ResponseWriter, r *http.Request) {
 	}
 
 	var feedItems []templates.FeedItem
+	ignored := gallery.NewIgnoreWalker()
+	access := requestAccess(r)
 	err := filepath.WalkDir(config.Global.Root,
 		func(walkPath string, entry os.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
-			if !entry.IsDir() && !gallery.ContainsDotFile(walkPath) &&
-				gallery.IsValidMedia(walkPath) {
+			if excludedWalkEntry(ignored, access, walkPath, entry) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.IsDir() && gallery.IsValidMedia(walkPath) &&
+				isClassShown(gallery.GetMediaClass(walkPath), opts) {
 				if info, err := entry.Info(); err == nil {
 					urlStr := pathToUrl(walkPath)
 					feedItems = append(feedItems, templates.FeedItem{
 						Type:  string(gallery.GetMediaClass(walkPath)),
 						Title: filepath.Base(walkPath),
+						Path:  walkPath,
 						Url:   urlStr,
-						Thumb: urlStr + "?thumb",
+						Thumb: urlStr + "?" + config.TokenQuery(requestUser(r), "thumb"),
 						Id:    urlStr,
 						Mdate: info.ModTime(),
 						Date:  formatTime(info.ModTime()),
@@ -622,6 +2198,25 @@ func feedHandler(feedType feed, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Describe only the entries in the feed
+	for i := range latestItems {
+		relPath := strings.TrimPrefix(latestItems[i].Path, config.Global.Root+"/")
+		item := []templates.ListItem{{
+			Path:        relPath,
+			Sidecar:     gallery.FindSidecar(relPath),
+			CaptionFile: gallery.FindCaptionFile(relPath),
+		}}
+		addMetadata(item)
+		if item[0].Title != "" {
+			latestItems[i].Title = item[0].Title
+		}
+		latestItems[i].Description = item[0].Caption
+		latestItems[i].Copyright = config.Global.Copyright
+		if meta := folderSettingsOf(path.Dir(relPath)); meta.Copyright != "" {
+			latestItems[i].Copyright = meta.Copyright
+		}
+	}
+
 	lastDate := time.Now()
 	if len(latestItems) > 0 {
 		lastDate = latestItems[0].Mdate
@@ -630,7 +2225,8 @@ func feedHandler(feedType feed, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Last-modified", lastDateStr)
 
 	feedTpl := templates.FeedPage{
-		FeedUrl:   config.Global.PublicUrl + "feed?" + string(feedType),
+		FeedUrl: config.Global.PublicUrl + "feed?" + feedQuery(opts) +
+			config.TokenQuery(requestUser(r), string(feedType)),
 		SiteTitle: config.Global.PublicHost,
 		SiteUrl:   config.Global.PublicUrl,
 		LastDate:  lastDateStr,
@@ -642,6 +2238,14 @@ func feedHandler(feedType feed, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Parameters of a feed before its type, like c/i/ for images only
+func feedQuery(opts config.RequestSettings) string {
+	if opts.Classes == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/", config.QKeyClass, opts.Classes)
+}
+
 // Parses a string like key1/value1/key2/value2 to a map.
 func parseQuery(q string) (m url.Values, err error) {
 	m = make(url.Values)
@@ -696,6 +2300,9 @@ func parseQuery(q string) (m url.Values, err error) {
 //   - list of folder items
 //   - view of an item (html)
 //   - preview image (thumbnail)
+//   - video embedded in a motion photo
+//   - search results
+//   - timeline of media and memories from earlier years
 //   - direct media file
 //   - info page about our running program
 //   - RSS (or atom) feed
@@ -711,6 +2318,30 @@ func HttpHandler(w http.ResponseWriter, r *http.Request) {
 	case q.Has("thumb"):
 		previewHandler(w, r)
 		return
+	case q.Has("motion"):
+		motionHandler(w, r)
+		return
+	case q.Has("search") || r.URL.Query().Has("search"):
+		searchHandler(w, r)
+		return
+	case q.Has("timeline"):
+		timelineHandler(w, r)
+		return
+	case q.Has("reset"):
+		resetHandler(w, r)
+		return
+	case q.Has("album"):
+		albumHandler(w, r)
+		return
+	case q.Has("zip"):
+		zipHandler(w, r)
+		return
+	case q.Has("share"):
+		shareHandler(w, r)
+		return
+	case q.Has("onthisday"):
+		memoriesHandler(w, r)
+		return
 	case q.Has("broken"): // Keep this separate from static, just in case...
 		staticHandler("res/broken.svg", w, r)
 		return
@@ -745,32 +2376,677 @@ func HttpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Folder with the defaults for a request: the requested one or, for media,
+// the folder it is listed in (up levels above its own)
+func settingsFolder(reqPath string, up int) string {
+	if stat, err := storage.Root.Stat(reqPath); err == nil && stat.IsDir() {
+		return reqPath
+	}
+	return folderUp(path.Dir(path.Clean("/"+reqPath)), up)
+}
+
+// Folder some levels above another, up to the root
+func folderUp(folder string, up int) string {
+	for ; up > 0 && folder != "/"; up-- {
+		folder = path.Dir(folder)
+	}
+	return folder
+}
+
+// Levels of folders above media, which links can go up
+func levelsAbove(reqPath string) int {
+	return max(0, strings.Count(path.Clean("/"+reqPath), "/")-1)
+}
+
 func paramHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		q, _ := parseQuery(r.URL.RawQuery)
-		rSettings := config.RequestSettingsFromQuery(q)
+		meta, _ := r.Context().Value(folderSettings).(config.FolderSettings)
+		rSettings := config.RequestSettingsWithDefaults(q, meta.RequestDefaults())
+		rSettings.Up = min(rSettings.Up, levelsAbove(strings.TrimPrefix(r.URL.Path, urlPrefix)))
+		prefs, hasPrefs := readPreferences(r)
+		if config.HasPreferences(q) {
+			savePreferences(w, r, meta.SavedPreferences(rSettings.Preferences(), prefs))
+		} else if hasPrefs {
+			rSettings = *rSettings.WithPreferences(meta.UserPreferences(prefs))
+		}
+		if rSettings.Sort == config.QuerySortShuffle && rSettings.Seed == 0 {
+			rSettings.Seed = newSeed()
+		}
 		sortCtx := context.WithValue(r.Context(), reqSettings, rSettings)
 		next.ServeHTTP(w, r.WithContext(sortCtx))
 	})
 }
 
+// Keeps the sort, order and display chosen by the user in a cookie
+func savePreferences(w http.ResponseWriter, r *http.Request, prefs config.RequestSettings) {
+	value, err := prefs.Marshal()
+	if err != nil {
+		return
+	}
+	if cookie, err := r.Cookie(preferencesCookie); err == nil && cookie.Value == value {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     preferencesCookie,
+		Value:    value,
+		Path:     urlPrefix + "/",
+		MaxAge:   int(preferencesMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Reads the preferences of the user, used when the URL does not set them
+func readPreferences(r *http.Request) (prefs config.RequestSettings, ok bool) {
+	cookie, err := r.Cookie(preferencesCookie)
+	if err != nil {
+		return
+	}
+	if err := prefs.Unmarshal(cookie.Value); err != nil {
+		return
+	}
+	return prefs, true
+}
+
+// Route to forget the preferences of the user and go back to the defaults
+func resetHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     preferencesCookie,
+		Path:     urlPrefix + "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, gallery.EscapePath(r.URL.Path), http.StatusFound)
+}
+
+// Adds the settings of the requested folder or, for media, of the folder
+// it is listed in
 func metadataHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		metafile := strings.TrimPrefix(r.URL.Path, urlPrefix)
-		if !config.HasFolderSettings(metafile) {
+		q, _ := parseQuery(r.URL.RawQuery)
+		up, _ := strconv.Atoi(q.Get(config.QKeyUp.String()))
+		folderPath := settingsFolder(strings.TrimPrefix(r.URL.Path, urlPrefix), up)
+		fs := folderSettingsOf(folderPath)
+		metaCtx := context.WithValue(r.Context(), folderSettings, fs)
+		next.ServeHTTP(w, r.WithContext(metaCtx))
+	})
+}
+
+// Value of the session cookie of a user: the name in hex, the expiry and
+// their signature
+func newSession(user string, expires time.Time) string {
+	name := hex.EncodeToString([]byte(user))
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return name + "." + expiry + "." + config.Sign("session", name, expiry)
+}
+
+// Finds the user of a session which is signed, not expired and whose user
+// can still log in
+func sessionUser(value string) (string, bool) {
+	name, rest, _ := strings.Cut(value, ".")
+	expiry, signature, _ := strings.Cut(rest, ".")
+	if !config.ValidSignature(signature, "session", name, expiry) {
+		return "", false
+	}
+	if unix, err := strconv.ParseInt(expiry, 10, 64); err != nil || time.Now().Unix() > unix {
+		return "", false
+	}
+	user, err := hex.DecodeString(name)
+	if err != nil || !config.HasUser(string(user)) {
+		return "", false
+	}
+	return string(user), true
+}
+
+// Logged in user of a request, empty when authentication is disabled
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(currentUser).(string)
+	return user
+}
+
+// Finds the user of a request by the session or, for feeds and
+// thumbnails, by the token in the query
+func authUser(r *http.Request, q url.Values) (string, bool) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if user, ok := sessionUser(cookie.Value); ok {
+			return user, true
+		}
+	}
+	if config.Global.FeedTokens && (q.Has("rss") || q.Has("atom") || q.Has("thumb")) {
+		return config.TokenUser(q.Get("token"))
+	}
+	return "", false
+}
+
+// Lets in only the users of the htpasswd file, when there is one. Others
+// get the login form instead of the page, but for what their share links
+// open. Static resources are public.
+func authHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.AuthEnabled() {
 			next.ServeHTTP(w, r)
 			return
 		}
-		fs, err := config.ReadFolderSettings(metafile)
-		if err != nil {
-			logger.Printf("metadata error: %v\n", err)
+		q, _ := parseQuery(r.URL.RawQuery)
+		switch {
+		case q.Has("login"):
+			loginHandler(w, r)
+			return
+		case q.Has("logout"):
+			logoutHandler(w, r)
+			return
+		case q.Has("static") || q.Has("broken") || r.URL.Path == "/favicon.ico":
 			next.ServeHTTP(w, r)
 			return
 		}
-		logger.Printf("metadata: %+v\n", fs)
-		metaCtx := context.WithValue(r.Context(), folderSettings, fs)
-		next.ServeHTTP(w, r.WithContext(metaCtx))
+		user, ok := authUser(r, q)
+		if !ok {
+			reqPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+			if q.Get("share") != "" || sharedRoute(r, q) &&
+				slices.ContainsFunc(requestShares(r), func(scope string) bool {
+					return config.InScope(scope, reqPath)
+				}) {
+				next.ServeHTTP(w, r) // Visitors with share links
+				return
+			}
+			loginPage(w, r, http.StatusUnauthorized, "", r.URL.RequestURI())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), currentUser, user)))
+	})
+}
+
+// Renders the login form, or the account of a logged in user
+func loginPage(w http.ResponseWriter, r *http.Request, status int, message, next string) {
+	if wantsJson(r) {
+		http.Error(w, cmp.Or(message, "login required"), status)
+		return
+	}
+	page := templates.LoginPage{
+		Page: templates.Page{
+			Title:        "log in",
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+		},
+		Copyright: config.Global.Copyright,
+		Message:   message,
+		Next:      next,
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if user, ok := sessionUser(cookie.Value); ok {
+			page.Title = "account"
+			page.User = user
+			if config.Global.FeedTokens {
+				page.FeedUrl = config.Global.PublicUrl + "feed?" +
+					config.TokenQuery(user, string(feedRss))
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := templates.Html.ExecuteTemplate(w, "login", &page); err != nil {
+		logger.Print(fmt.Errorf("login page error: %w", err))
+	}
+}
+
+// Failed password attempts allowed before each next one has to wait, and
+// the longest wait, which doubles with every failure after them
+var (
+	freeAttempts   = 5
+	attemptWait    = time.Second
+	maxAttemptWait = 15 * time.Minute
+)
+
+// Failed password attempts by the address they came from, to slow down
+// guessing. Addresses are forgotten some time after their last failure.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	failures map[string]failedAttempts
+}
+
+type failedAttempts struct {
+	count int
+	last  time.Time
+}
+
+var (
+	loginAttempts  = attemptLimiter{failures: make(map[string]failedAttempts)}
+	unlockAttempts = attemptLimiter{failures: make(map[string]failedAttempts)}
+)
+
+// Address of the client of a request, without its port
+func clientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Tells how long an address waits before its next attempt, zero when it
+// can try now
+func (l *attemptLimiter) wait(addr string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failed, ok := l.failures[addr]
+	if !ok || failed.count < freeAttempts {
+		return 0
+	}
+	backoff := attemptWait << min(failed.count-freeAttempts, 30)
+	return max(0, time.Until(failed.last.Add(min(backoff, maxAttemptWait))))
+}
+
+// Counts a failed attempt of an address
+func (l *attemptLimiter) fail(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for key, failed := range l.failures {
+		if now.Sub(failed.last) > 2*maxAttemptWait {
+			delete(l.failures, key)
+		}
+	}
+	failed := l.failures[addr]
+	l.failures[addr] = failedAttempts{count: failed.count + 1, last: now}
+}
+
+// Forgets the failed attempts of an address after a right password
+func (l *attemptLimiter) succeed(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, addr)
+}
+
+// Message for clients which have to wait before trying again
+func attemptsMessage(w http.ResponseWriter, wait time.Duration) string {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return fmt.Sprintf("too many failed attempts, try again in %v", time.Duration(seconds)*time.Second)
+}
+
+// Page to go to after logging in, only in this site. Browsers drop control
+// characters, so /<tab>/host would go to another site.
+func loginNext(next string) string {
+	parsed, err := url.Parse(next)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.Contains(next, "\\") || strings.ContainsFunc(next, unicode.IsControl) {
+		return urlPrefix + "/"
+	}
+	return next
+}
+
+// Route for the login form (GET) and for logging in with it (POST)
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		loginPage(w, r, http.StatusOK, "", loginNext(r.URL.Query().Get("next")))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loginPage(w, r, http.StatusBadRequest, err.Error(), urlPrefix+"/")
+		return
+	}
+	user, next := r.PostForm.Get("user"), loginNext(r.PostForm.Get("next"))
+	addr := clientAddr(r)
+	if wait := loginAttempts.wait(addr); wait > 0 {
+		loginPage(w, r, http.StatusTooManyRequests, attemptsMessage(w, wait), next)
+		return
+	}
+	if !config.CheckPassword(user, r.PostForm.Get("password")) {
+		loginAttempts.fail(addr)
+		logger.Printf("login failed: %q from %s\n", user, r.RemoteAddr)
+		loginPage(w, r, http.StatusUnauthorized, "wrong user or password", next)
+		return
+	}
+	loginAttempts.succeed(addr)
+	expires := time.Now().Add(sessionMaxAge)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    newSession(user, expires),
+		Path:     urlPrefix + "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// Route to log out, forgetting the session
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     urlPrefix + "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, urlPrefix+"/", http.StatusSeeOther)
+}
+
+// Folders the user of a request can see
+func requestAccess(r *http.Request) *config.FolderAccess {
+	if access, ok := r.Context().Value(folderAccess).(*config.FolderAccess); ok {
+		return access
+	}
+	return config.NewFolderAccess(requestUser(r)).WithUnlocked(unlockedBy(r))
+}
+
+// Hides folders and their media from users whom the access rules do not
+// let in, as if they did not exist. Folders with a password show the form
+// to unlock them instead. Share links open their folder or file in the
+// routes for them.
+func accessHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		access := config.NewFolderAccess(user).WithUnlocked(unlockedBy(r))
+		q, _ := parseQuery(r.URL.RawQuery)
+		if sharedRoute(r, q) {
+			access.WithShared(requestShares(r)...)
+		}
+		if config.AuthEnabled() && user == "" {
+			access.OnlyShared()
+		}
+		reqPath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+		stat, err := storage.Root.Stat(reqPath)
+		isDir := err == nil && stat.IsDir()
+		folderPath := reqPath
+		if !isDir {
+			folderPath = path.Dir(reqPath)
+		}
+		switch {
+		case q.Has("static") || q.Has("broken"):
+		case q.Get("share") != "":
+			openShareHandler(w, r, q.Get("share"))
+			return
+		case q.Has("unlock"):
+			unlockHandler(w, r, access, folderPath)
+			return
+		case !access.AllowsPath(reqPath, isDir):
+			if locked := access.LockedFolder(folderPath); locked != "" {
+				unlockPage(w, r, http.StatusUnauthorized, locked, "", r.URL.RequestURI())
+				return
+			}
+			fail404(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), folderAccess, access)))
+	})
+}
+
+// Name of the cookie which unlocks a folder with a password
+func unlockCookieName(folder string) string {
+	return unlockCookie + config.Sign("unlock-cookie", folder)[:16]
+}
+
+// Tells if a request has the cookie unlocking a folder with the password
+// of a hash. Cookies of an old password do not unlock the folder.
+func unlockedBy(r *http.Request) func(folder, hash string) bool {
+	return func(folder, hash string) bool {
+		cookie, err := r.Cookie(unlockCookieName(folder))
+		return err == nil && config.ValidSignature(cookie.Value, "unlock", folder, hash)
+	}
+}
+
+// Renders the form to unlock a folder with a password
+func unlockPage(w http.ResponseWriter, r *http.Request, status int, folder, message, next string) {
+	if wantsJson(r) {
+		http.Error(w, cmp.Or(message, "password required"), status)
+		return
+	}
+	page := templates.UnlockPage{
+		Page: templates.Page{
+			Title:        path.Base(folder),
+			Prefix:       urlPrefix,
+			AppVersion:   BuildVersion,
+			AppBuildTime: BuildTimestamp,
+		},
+		Copyright: config.Global.Copyright,
+		Message:   message,
+		Action:    gallery.EscapePath(urlPrefix+folder) + "?unlock",
+		Next:      next,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := templates.Html.ExecuteTemplate(w, "unlock", &page); err != nil {
+		logger.Print(fmt.Errorf("unlock page error: %w", err))
+	}
+}
+
+// Route for the form to unlock a folder with a password (GET) and for
+// unlocking it (POST), until the browser is closed
+func unlockHandler(w http.ResponseWriter, r *http.Request, access *config.FolderAccess, folderPath string) {
+	locked := access.LockedFolder(folderPath)
+	if locked == "" {
+		if !access.Allows(folderPath) {
+			fail404(w, r)
+			return
+		}
+		http.Redirect(w, r, gallery.EscapePath(urlPrefix+folderPath), http.StatusFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		next := cmp.Or(r.URL.Query().Get("next"), gallery.EscapePath(urlPrefix+folderPath))
+		unlockPage(w, r, http.StatusOK, locked, "", loginNext(next))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		unlockPage(w, r, http.StatusBadRequest, locked, err.Error(), urlPrefix+"/")
+		return
+	}
+	next := loginNext(r.PostForm.Get("next"))
+	addr := clientAddr(r)
+	if wait := unlockAttempts.wait(addr); wait > 0 {
+		unlockPage(w, r, http.StatusTooManyRequests, locked, attemptsMessage(w, wait), next)
+		return
+	}
+	own, _ := config.OwnFolderSettings(locked)
+	if !config.CheckFolderPassword(own.Password, r.PostForm.Get("password")) {
+		unlockAttempts.fail(addr)
+		logger.Printf("unlock failed: %q from %s\n", locked, r.RemoteAddr)
+		unlockPage(w, r, http.StatusUnauthorized, locked, "wrong password", next)
+		return
+	}
+	unlockAttempts.succeed(addr)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(locked),
+		Value:    config.Sign("unlock", locked, own.Password),
+		Path:     urlPrefix + "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// Routes which share links do not open, leaving lists, media pages, files
+// and thumbnails
+var unsharedRoutes = []string{"status", "search", "timeline", "album", "zip",
+	"onthisday", "rss", "atom", "share", "unlock"}
+
+// Tells if share links open the route of a request
+func sharedRoute(r *http.Request, q url.Values) bool {
+	return !slices.ContainsFunc(unsharedRoutes, q.Has) && !r.URL.Query().Has("search")
+}
+
+// Folders and files of the share links in the cookies of a request, which
+// are still valid
+func requestShares(r *http.Request) []string {
+	var scopes []string
+	for _, cookie := range r.Cookies() {
+		if !strings.HasPrefix(cookie.Name, shareCookie) {
+			continue
+		}
+		if scope, _, ok := config.SharedScope(cookie.Value); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// Parses how long a share link opens for, like 36h or 7d
+func parseShareDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var count int
+		count, err = strconv.Atoi(days)
+		duration = time.Duration(min(count, 1000)) * 24 * time.Hour
+	}
+	if err != nil || duration <= 0 || duration > maxShareDuration {
+		return 0, fmt.Errorf("invalid duration %q, use one like 12h or 7d up to a year", value)
+	}
+	return duration, nil
+}
+
+// Public URL of a share link to a folder or file
+func shareLink(relPath, token string) string {
+	escaped := gallery.EscapePath(path.Clean("/" + relPath))
+	return config.Global.PublicUrl + strings.TrimPrefix(escaped, "/") + "?share/" + token
+}
+
+// Renders the form to share a folder or file, its share link or why a
+// link no longer opens
+func sharePage(w http.ResponseWriter, r *http.Request, status int, page templates.SharePage) {
+	if wantsJson(r) && page.Message != "" {
+		http.Error(w, page.Message, status)
+		return
+	}
+	page.Page = templates.Page{
+		Title:        page.Title,
+		Prefix:       urlPrefix,
+		AppVersion:   BuildVersion,
+		AppBuildTime: BuildTimestamp,
+	}
+	page.Copyright = config.Global.Copyright
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := templates.Html.ExecuteTemplate(w, "share", &page); err != nil {
+		logger.Print(fmt.Errorf("share page error: %w", err))
+	}
+}
+
+// Route for the form to share a folder or file (GET) and for making its
+// share link (POST), which opens it until it expires
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	relPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, urlPrefix))
+	if _, err := storage.Root.Stat(relPath); err != nil || gallery.IsExcludedPath(relPath) {
+		fail404(w, r)
+		return
+	}
+	page := templates.SharePage{
+		Page:   templates.Page{Title: path.Base(relPath)},
+		Action: gallery.EscapePath(urlPrefix+relPath) + "?share",
+	}
+	if r.Method != http.MethodPost {
+		sharePage(w, r, http.StatusOK, page)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		page.Message = err.Error()
+		sharePage(w, r, http.StatusBadRequest, page)
+		return
+	}
+	duration, err := parseShareDuration(r.PostForm.Get("expires"))
+	if err != nil {
+		page.Message = err.Error()
+		sharePage(w, r, http.StatusBadRequest, page)
+		return
+	}
+	page.Expires = time.Now().Add(duration)
+	token := config.ShareToken(relPath, page.Expires)
+	page.Link, page.Id = shareLink(relPath, token), config.ShareId(token)
+	logger.Printf("share link %s: %q until %v by %q from %s\n",
+		page.Id, relPath, page.Expires, requestUser(r), r.RemoteAddr)
+	sharePage(w, r, http.StatusOK, page)
+}
+
+// Route of share links, which keeps the link in a cookie until it expires
+// and goes to its folder or file
+func openShareHandler(w http.ResponseWriter, r *http.Request, token string) {
+	scope, expires, ok := config.SharedScope(token)
+	if !ok {
+		sharePage(w, r, http.StatusGone, templates.SharePage{
+			Page:    templates.Page{Title: "share link"},
+			Message: "This link has expired or was revoked.",
+		})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie + config.ShareId(token),
+		Value:    token,
+		Path:     urlPrefix + "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
 	})
+	http.Redirect(w, r, gallery.EscapePath(urlPrefix+scope), http.StatusSeeOther)
+}
+
+// Link to the form to share a folder or file, for those who can make
+// share links
+func shareFormLink(r *http.Request, itemUrl string) string {
+	if config.AuthEnabled() && requestUser(r) == "" {
+		return ""
+	}
+	return itemUrl + "?share"
+}
+
+// Tells if the arguments are the share command instead of the folder
+func shareCommand() bool {
+	return flag.NArg() == 3 && flag.Arg(0) == "share"
+}
+
+// Prints the share link to a path of the gallery, for a duration
+func printShareLink(relPath, expiresIn string) error {
+	if config.Global.Secret == "" {
+		return errors.New("share links need the secret setting")
+	}
+	relPath = path.Clean("/" + filepath.ToSlash(relPath))
+	if gallery.ContainsDotFile(relPath) {
+		return fmt.Errorf("invalid path: %s", relPath)
+	}
+	if _, err := os.Stat(filepath.Join(config.Global.Root, filepath.FromSlash(relPath))); err != nil {
+		return err
+	}
+	duration, err := parseShareDuration(expiresIn)
+	if err != nil {
+		return err
+	}
+	urlPrefix = prefixPath()
+	config.Global.PublicUrl = publicUrl(
+		fileExists(config.Global.TlsCrt) && fileExists(config.Global.TlsKey))
+	expires := time.Now().Add(duration)
+	token := config.ShareToken(relPath, expires)
+	fmt.Println(shareLink(relPath, token))
+	fmt.Fprintf(os.Stderr, "Opens %s until %v, id %s\n",
+		relPath, expires.Format(time.DateTime), config.ShareId(token))
+	return nil
+}
+
+// Path prefix of the URLs, like /PREFIX
+func prefixPath() string {
+	if config.Global.Prefix == "" {
+		return ""
+	}
+	return fmt.Sprintf("/%s", strings.Trim(config.Global.Prefix, "/"))
+}
+
+// Public URL of the gallery with the prefix, like http://host/PREFIX/
+func publicUrl(useTls bool) string {
+	address := fmt.Sprintf("%s:%d", config.Global.Host, config.Global.Port) + urlPrefix + "/"
+	if config.Global.PublicHost != "" {
+		address = strings.Trim(config.Global.PublicHost, "/") + urlPrefix + "/"
+	}
+	if useTls {
+		return "https://" + address
+	}
+	return "http://" + address
 }
 
 func initGlobalsAndFlags() error {
@@ -793,7 +3069,9 @@ func initGlobalsAndFlags() error {
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] [FOLDER]\n", filepath.Base(os.Args[0]))
-		fmt.Fprintf(os.Stderr, "\tFOLDER overrides \"--root\".\n\n")
+		fmt.Fprintf(os.Stderr, "       %s [options] share PATH DURATION\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\tFOLDER overrides \"--root\".\n")
+		fmt.Fprintf(os.Stderr, "\tshare prints a link opening PATH of the gallery for DURATION, like 7d.\n\n")
 		fmt.Printf("Options:\n")
 		flag.PrintDefaults()
 	}
@@ -807,6 +3085,8 @@ func initGlobalsAndFlags() error {
 		"folder used to keep thumbnails (by default a temporary folder is created and auto-removed on exit)")
 	flag.StringVar(&config.Global.Root, "root", config.Global.Root,
 		"root folder to serve files from")
+	flag.StringVar(&config.Global.AlbumsDir, "albums-dir", config.Global.AlbumsDir,
+		"folder with album files (yaml) of the root folder")
 	flag.StringVar(&config.Global.Prefix, "prefix", config.Global.Prefix,
 		"path prefix as in http://localhost/PREFIX/other/stuff")
 	flag.StringVar(&config.Global.TlsCrt, "tls-crt", config.Global.TlsCrt,
@@ -829,6 +3109,27 @@ func initGlobalsAndFlags() error {
 	flag.StringVar(&config.Global.DiscordName,
 		"discord-name", config.Global.DiscordName,
 		"name to appear on sent notifications")
+	flag.BoolVar(&config.Global.NotifyMemories,
+		"notify-memories", config.Global.NotifyMemories,
+		"send media taken on the day in earlier years daily with the webhook")
+	flag.StringVar(&config.Global.DiscordUser,
+		"discord-user", config.Global.DiscordUser,
+		"user whose token opens thumbnails in notifications (with --feed-tokens)")
+	flag.StringVar(&config.Global.Htpasswd,
+		"htpasswd", config.Global.Htpasswd,
+		"htpasswd file (bcrypt) of the users who can log in; anyone can visit without it")
+	flag.StringVar(&config.Global.Groups,
+		"groups", config.Global.Groups,
+		"file with lines \"group: user1 user2\" for the access rules of folders")
+	flag.StringVar(&config.Global.Secret,
+		"secret", config.Global.Secret,
+		"key to sign sessions and share links (random on each start by default)")
+	flag.StringVar(&config.Global.RevokedShares,
+		"revoked-shares", config.Global.RevokedShares,
+		"file with the ids of share links which no longer open, one per line")
+	flag.BoolVar(&config.Global.FeedTokens,
+		"feed-tokens", config.Global.FeedTokens,
+		"open feeds and thumbnails with the token of a user, without logging in")
 	flag.StringVar(&config.Global.PublicHost,
 		"pub-host", config.Global.PublicHost,
 		"the public name for the machine")
@@ -836,6 +3137,11 @@ func initGlobalsAndFlags() error {
 		"thumb-width", config.Global.ThumbWidth, "width for thumbnails")
 	flag.IntVar(&config.Global.ThumbHeight,
 		"thumb-height", config.Global.ThumbHeight, "height for thumbnails")
+	flag.IntVar(&config.Global.PageSize,
+		"page-size", config.Global.PageSize, "items on a list page (0 to show all)")
+	flag.IntVar(&config.Global.ZipMaxSize,
+		"zip-max-size", config.Global.ZipMaxSize,
+		"MiB of folders downloaded as ZIP files (0 to disable downloads)")
 	flag.StringVar(&config.Global.ConfigFile,
 		"config", config.Global.ConfigFile,
 		"json file to get all the parameters from")
@@ -843,12 +3149,17 @@ func initGlobalsAndFlags() error {
 		"copyright", config.Global.Copyright,
 		"text to appear at the bottom of every page")
 
+	flag.Var(&config.Global.GroupExtensions, "group-extensions",
+		"comma separated extensions of files grouped by name, preferred first")
+	flag.Var(&config.Global.Ignore, "ignore",
+		"comma separated patterns of hidden files and folders")
+
 	flag.Bool("version", false, "show program version and build time")
 
 	flag.Parse()
 
 	rootArg := flag.Arg(0)
-	if rootArg != "" {
+	if rootArg != "" && !shareCommand() {
 		config.Global.Root = rootArg
 	}
 	return nil
@@ -880,6 +3191,14 @@ func main() {
 			return
 		}
 	}
+	if shareCommand() {
+		config.Global.Root, _ = filepath.Abs(config.Global.Root)
+		if err := printShareLink(flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Println(err)
+			exitCode = 1
+		}
+		return
+	}
 	if config.Global.Home == "" {
 		if !config.Global.Quiet {
 			log.Println("Creating temporary home folder...")
@@ -903,6 +3222,9 @@ func main() {
 		config.Global.Home, _ = filepath.Abs(config.Global.Home)
 	}
 	config.Global.Root, _ = filepath.Abs(config.Global.Root)
+	if config.Global.AlbumsDir != "" {
+		config.Global.AlbumsDir, _ = filepath.Abs(config.Global.AlbumsDir)
+	}
 
 	// Set up time location
 	if config.Global.TimeZone == "" {
@@ -940,6 +3262,39 @@ func main() {
 			config.Global.TimeLocation.String(), config.Global.TimeZone)
 	}
 
+	// Set up authentication
+	if config.Global.Secret == "" {
+		config.Global.Secret = config.NewSecret()
+		if config.AuthEnabled() {
+			infoF("No secret is set, sessions and share links end on restart")
+		}
+	}
+	if config.Global.Groups != "" {
+		config.Global.Groups, _ = filepath.Abs(config.Global.Groups)
+		if _, err := config.Groups(); err != nil {
+			infoF("Groups file: %v", err)
+		}
+	}
+	if config.Global.RevokedShares != "" {
+		config.Global.RevokedShares, _ = filepath.Abs(config.Global.RevokedShares)
+		if _, err := config.Revoked(); err != nil {
+			infoF("Revoked shares file: %v", err)
+		}
+	}
+	if config.AuthEnabled() {
+		config.Global.Htpasswd, _ = filepath.Abs(config.Global.Htpasswd)
+		users, err := config.Users()
+		if users == nil {
+			log.Printf("Users cannot be read: %v", err)
+			exitCode = 1
+			return
+		}
+		if err != nil {
+			infoF("Users file: %v", err)
+		}
+		infoF("Users who can log in: %d", len(users))
+	}
+
 	// Set root media folder
 	if exists, err := os.Stat(config.Global.Root); os.IsNotExist(err) || !exists.IsDir() {
 		log.Printf("Root folder does not exist: %v", config.Global.Root)
@@ -981,7 +3336,7 @@ func main() {
 	// Routing
 	httpmux := http.NewServeMux()
 	if config.Global.Prefix != "" {
-		urlPrefix = fmt.Sprintf("/%s", strings.Trim(config.Global.Prefix, "/"))
+		urlPrefix = prefixPath()
 		httpmux.Handle(urlPrefix,
 			http.StripPrefix(urlPrefix, http.HandlerFunc(HttpHandler)))
 	}
@@ -994,6 +3349,8 @@ func main() {
 
 	paramMux := paramHandler(httpmux)
 	metaMux := metadataHandler(paramMux)
+	accessMux := accessHandler(metaMux)
+	authMux := authHandler(accessMux)
 
 	ffmpegPath := config.Global.Ffmpeg
 	if config.Global.Ffmpeg == "" {
@@ -1013,22 +3370,15 @@ func main() {
 		infoF("TLS certificate: %s, key: %s",
 			config.Global.TlsCrt, config.Global.TlsKey)
 	}
-	if config.Global.DiscordWebhook != "" { // Start filesystem watcher
-		go gallery.StartFsWatcher()
-	}
-
-	if config.Global.PublicHost != "" {
-		config.Global.PublicUrl = strings.Trim(config.Global.PublicHost, "/") +
-			urlPrefix + "/"
-	} else {
-		config.Global.PublicUrl = bind + urlPrefix + "/"
+	go func() { // Index names for search before watching for changes
+		gallery.BuildIndex()
+		gallery.StartFsWatcher()
+	}()
+	if config.Global.NotifyMemories && config.Global.DiscordWebhook != "" {
+		go gallery.StartMemoriesNotifier()
 	}
 
-	if useTls {
-		config.Global.PublicUrl = "https://" + config.Global.PublicUrl
-	} else {
-		config.Global.PublicUrl = "http://" + config.Global.PublicUrl
-	}
+	config.Global.PublicUrl = publicUrl(useTls)
 	logger.Printf("Running server at: %v", config.Global.PublicUrl)
 	if !config.Global.Quiet {
 		log.Printf("Running server at: %v\nPress ^C to stop...\n",
@@ -1041,7 +3391,7 @@ func main() {
 	srv := &http.Server{
 		ReadHeaderTimeout: headerTimeout,
 		Addr:              bind,
-		Handler:           metaMux,
+		Handler:           authMux,
 	}
 	if useTls { // Prepare the TLS
 		tlsConfig := &tls.Config{
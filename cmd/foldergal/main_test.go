@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
 	"specto.org/projects/foldergal/internal/templates"
+
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // func assertResponseBody(t testing.TB, got, want string) {
@@ -59,6 +73,8 @@ func assertStatus(t testing.TB, got, want int) {
 func TestMain(m *testing.M) {
 	os.Chdir("../..")
 	fmt.Println("-> Preparing...")
+	config.Global.Root = "."
+	storage.Root = afero.NewBasePathFs(afero.NewOsFs(), config.Global.Root)
 	result := m.Run()
 	fmt.Println("-> Finishing...")
 	os.Exit(result)
@@ -147,7 +163,7 @@ func Test_splitUrlToBreadCrumbs(t *testing.T) {
 
 func Test_mediaCount(t *testing.T) {
 	var expected int64 = 5
-	if result := mediaCount("./cmd/foldergal/testdata"); expected != result {
+	if result := mediaCount("./cmd/foldergal/testdata", config.NewFolderAccess("")); expected != result {
 		t.Fatalf("mediaCount got: %v, expected: %v", result, expected)
 	}
 }
@@ -155,7 +171,7 @@ func Test_mediaCount(t *testing.T) {
 
 func Test_folderMediaSize(t *testing.T) {
 	var expected int64 = 48821
-	if result := folderMediaSize("./cmd/foldergal/testdata"); expected != result {
+	if result := folderMediaSize("./cmd/foldergal/testdata", config.NewFolderAccess("")); expected != result {
 		t.Fatalf("folderMediaSize got: %v, expected: %v", result, expected)
 	}
 }
@@ -350,3 +366,745 @@ func Test_parseQuery(t *testing.T) {
 	}
 }
 
+func Test_wantsJson(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   bool
+	}{
+		{"", "", false},
+		{"json", "", true},
+		{"s/n/json", "", true},
+		{"", "application/json", true},
+		{"", "text/html,application/xhtml+xml", false},
+		{"s/n", "", false},
+	}
+	for _, tc := range tests {
+		request, _ := http.NewRequest(http.MethodGet, "/?"+tc.query, http.NoBody)
+		request.Header.Set("Accept", tc.accept)
+		if result := wantsJson(request); result != tc.want {
+			t.Errorf("wantsJson(%q, %q) = %v, want %v", tc.query, tc.accept, result, tc.want)
+		}
+	}
+}
+
+func Test_pageOf(t *testing.T) {
+	tests := []struct {
+		page, total, limit int
+		want, wantPages    int
+	}{
+		{0, 100, 0, 1, 1},
+		{0, 100, 10, 1, 10},
+		{3, 101, 10, 3, 11},
+		{99, 20, 10, 2, 2},
+		{2, 0, 10, 1, 1},
+	}
+	for _, tc := range tests {
+		page, pages := pageOf(tc.page, tc.total, tc.limit)
+		if page != tc.want || pages != tc.wantPages {
+			t.Errorf("pageOf(%v, %v, %v) = %v, %v, want %v, %v",
+				tc.page, tc.total, tc.limit, page, pages, tc.want, tc.wantPages)
+		}
+	}
+}
+
+func Test_pageLinks(t *testing.T) {
+	titles := func(links []templates.Link) (result []string) {
+		for _, link := range links {
+			result = append(result, link.Title)
+		}
+		return
+	}
+	tests := []struct {
+		page, pages int
+		want        []string
+	}{
+		{1, 1, nil},
+		{1, 3, []string{"1", "2", "3"}},
+		{1, 10, []string{"1", "2", "3", "…", "10"}},
+		{6, 10, []string{"1", "…", "4", "5", "6", "7", "8", "…", "10"}},
+		{4, 5, []string{"1", "2", "3", "4", "5"}},
+	}
+	for _, tc := range tests {
+		result := titles(pageLinks("/a", tc.page, tc.pages, config.NewRequestSettings()))
+		if !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("pageLinks(%v, %v) = %v, want %v", tc.page, tc.pages, result, tc.want)
+		}
+	}
+	links := pageLinks("/a", 2, 3, config.NewRequestSettings())
+	if links[0].Url != "/a" || links[2].Url != "/a?p/3" || !links[1].Current {
+		t.Errorf("pageLinks urls: %+v", links)
+	}
+}
+
+func Test_timelineMonths(t *testing.T) {
+	item := func(name string, month time.Month, day int) templates.ListItem {
+		return templates.ListItem{Name: name, Taken: time.Date(2024, month, day, 12, 0, 0, 0, time.UTC)}
+	}
+	items := []templates.ListItem{item("a", 5, 3), item("b", 5, 3), item("c", 5, 1), item("d", 2, 29)}
+	var result []string
+	for _, month := range timelineMonths(items) {
+		result = append(result, month.Id)
+		for _, day := range month.Days {
+			result = append(result, fmt.Sprintf("%v:%v", day.Id, len(day.Items)))
+		}
+	}
+	want := []string{"2024-05", "2024-05-03:2", "2024-05-01:1", "2024-02", "2024-02-29:1"}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("timelineMonths() = %v, want %v", result, want)
+	}
+	if months := timelineMonths(nil); months != nil {
+		t.Errorf("timelineMonths(nil) = %v, want nil", months)
+	}
+}
+
+func Test_timelineUrl(t *testing.T) {
+	opts := config.NewRequestSettings()
+	sorted := *opts.WithOrder(config.QueryOrderAsc)
+	tests := []struct {
+		year int
+		opts config.RequestSettings
+		want string
+	}{
+		{0, opts, "/a?timeline"},
+		{2024, opts, "/a?timeline/2024"},
+		{0, sorted, "/a?o/a/timeline"},
+		{2024, sorted, "/a?timeline/2024/o/a"},
+	}
+	for _, tc := range tests {
+		if result := timelineUrl("/a", tc.year, tc.opts); result != tc.want {
+			t.Errorf("timelineUrl(%v, %v) = %v, want %v", tc.year, tc.opts, result, tc.want)
+		}
+	}
+}
+
+func Test_itemSettings(t *testing.T) {
+	recursive := config.NewRequestSettings()
+	recursive.Display = config.QueryDisplayRecursive
+	recursive.Page = 2
+	tests := []struct {
+		opts   config.RequestSettings
+		folder string
+		item   string
+		wantUp int
+	}{
+		{recursive, "/trip", "/trip/a.jpg", 0},
+		{recursive, "/trip", "/trip/day1/a.jpg", 1},
+		{recursive, "/", "/trip/day1/a.jpg", 2},
+		{recursive, "", "trip/a.jpg", 1},
+		{config.NewRequestSettings(), "/trip", "/trip/day1/a.jpg", 0},
+	}
+	for _, tc := range tests {
+		result := itemSettings(tc.opts, tc.folder, tc.item)
+		if result.Up != tc.wantUp || result.Page != 0 {
+			t.Errorf("itemSettings(%v, %v) = %+v, want up %v",
+				tc.folder, tc.item, result, tc.wantUp)
+		}
+	}
+}
+
+func Test_filterItems(t *testing.T) {
+	items := []templates.ListItem{
+		{Name: "folder", Class: "folder"},
+		{Name: "a.jpg", Class: "image nothumb", Rating: 3, Keywords: []string{"Sea"}},
+		{Name: "b.mp4", Class: "video", Rating: 5},
+		{Name: "c.pdf", Class: "pdf"},
+	}
+	names := func(items []templates.ListItem) (result []string) {
+		for _, item := range items {
+			result = append(result, item.Name)
+		}
+		return
+	}
+	tests := []struct {
+		opts config.RequestSettings
+		want []string
+	}{
+		{config.RequestSettings{}, []string{"folder", "a.jpg", "b.mp4", "c.pdf"}},
+		{config.RequestSettings{Classes: "iv"}, []string{"folder", "a.jpg", "b.mp4"}},
+		{config.RequestSettings{Classes: "p"}, []string{"folder", "c.pdf"}},
+		{config.RequestSettings{Rating: 4}, []string{"folder", "b.mp4"}},
+		{config.RequestSettings{Keyword: "sea", Classes: "i"}, []string{"folder", "a.jpg"}},
+	}
+	for _, tc := range tests {
+		if result := names(filterItems(items, tc.opts)); !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("filterItems(%+v) = %v, want %v", tc.opts, result, tc.want)
+		}
+	}
+}
+
+func Test_itemSorter(t *testing.T) {
+	items := []templates.ListItem{
+		{Name: "b.mp4", Path: "/b.mp4", Class: "video", Size: 30, Duration: time.Minute},
+		{Name: "a.jpg", Path: "/a.jpg", Class: "image", Size: 20, Width: 10, Height: 10, Position: 2},
+		{Name: "c.png", Path: "/c.png", Class: "image", Size: 20, Width: 40, Height: 30},
+		{Name: "d.mp3", Path: "/d.mp3", Class: "audio", Size: 10, Duration: time.Hour, Position: 1},
+	}
+	names := func(items []templates.ListItem) (result []string) {
+		for _, item := range items {
+			result = append(result, item.Name)
+		}
+		return
+	}
+	tests := []struct {
+		sort  config.QTypeSort
+		order config.QTypeOrder
+		want  []string
+	}{
+		{config.QuerySortSize, config.QueryOrderAsc, []string{"d.mp3", "a.jpg", "c.png", "b.mp4"}},
+		{config.QuerySortSize, config.QueryOrderDesc, []string{"b.mp4", "c.png", "a.jpg", "d.mp3"}},
+		{config.QuerySortType, config.QueryOrderAsc, []string{"d.mp3", "a.jpg", "c.png", "b.mp4"}},
+		{config.QuerySortPixels, config.QueryOrderDesc, []string{"c.png", "a.jpg", "d.mp3", "b.mp4"}},
+		{config.QuerySortDuration, config.QueryOrderDesc, []string{"d.mp3", "b.mp4", "c.png", "a.jpg"}},
+		{config.QuerySortCustom, config.QueryOrderAsc, []string{"d.mp3", "a.jpg", "b.mp4", "c.png"}},
+		{config.QuerySortCustom, config.QueryOrderDesc, []string{"a.jpg", "d.mp3", "b.mp4", "c.png"}},
+	}
+	for _, tc := range tests {
+		sorted := slices.Clone(items)
+		opts := config.RequestSettings{Sort: tc.sort, Order: tc.order}
+		sort.Slice(sorted, itemSorter(sorted, opts))
+		if result := names(sorted); !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("itemSorter(%v, %v) = %v, want %v", tc.sort, tc.order, result, tc.want)
+		}
+	}
+
+	shuffled := func(seed int64) []string {
+		sorted := slices.Clone(items)
+		opts := config.RequestSettings{Sort: config.QuerySortShuffle, Seed: seed}
+		sort.Slice(sorted, itemSorter(sorted, opts))
+		return names(sorted)
+	}
+	if !reflect.DeepEqual(shuffled(42), shuffled(42)) {
+		t.Errorf("shuffle is not stable with the same seed")
+	}
+}
+
+func Test_paramHandlerPreferences(t *testing.T) {
+	var opts config.RequestSettings
+	handler := paramHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts = r.Context().Value(reqSettings).(config.RequestSettings)
+	}))
+	serve := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	cookies := serve("/a?s/n/o/z").Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != preferencesCookie {
+		t.Fatalf("preferences are not saved: %v", cookies)
+	}
+	serve("/b", cookies...)
+	if opts.Sort != config.QuerySortName || opts.Order != config.QueryOrderDesc {
+		t.Errorf("preferences are not applied: %+v", opts)
+	}
+	if result := opts.QueryString(); result != "?o/z/s/n" {
+		t.Errorf("links with preferences = %v, want ?o/z/s/n", result)
+	}
+	serve("/b?s/b", cookies...)
+	if opts.Sort != config.QuerySortSize || opts.Order != config.DefaultOrder(config.QuerySortSize) {
+		t.Errorf("query does not override preferences: %+v", opts)
+	}
+	if saved := serve("/c?s/n/o/z", cookies...).Result().Cookies(); len(saved) != 0 {
+		t.Errorf("same preferences are saved again: %v", saved)
+	}
+	serve("/b", &http.Cookie{Name: preferencesCookie, Value: "invalid"})
+	if opts.Sort != config.QuerySortDefault {
+		t.Errorf("invalid preferences are applied: %+v", opts)
+	}
+}
+
+func Test_paramHandlerFolderPreferences(t *testing.T) {
+	var opts config.RequestSettings
+	handler := paramHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts = r.Context().Value(reqSettings).(config.RequestSettings)
+	}))
+	manuals := config.FolderSettings{Sort: "name", Order: "asc"}
+	events := config.FolderSettings{Sort: "date", Order: "asc", Display: "folder"}
+	serve := func(target string, meta config.FolderSettings, cookies []*http.Cookie) []*http.Cookie {
+		request := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		request = request.WithContext(context.WithValue(request.Context(), folderSettings, meta))
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Result().Cookies()
+	}
+	check := func(name string, sort config.QTypeSort, order config.QTypeOrder, display config.QTypeDisplay) {
+		t.Helper()
+		if opts.Sort != sort || opts.Order != order || opts.Display != display {
+			t.Errorf("%s: settings = %v %v %v, want %v %v %v", name,
+				opts.Sort, opts.Order, opts.Display, sort, order, display)
+		}
+	}
+
+	// Links carry the sort and order with the display, only the table is chosen
+	table := serve("/a?y/t/o/z/s/d", config.FolderSettings{}, nil)
+	serve("/manuals", manuals, table)
+	check("manuals after table", config.QuerySortName, config.QueryOrderAsc, config.QueryDisplayTable)
+	serve("/events", events, table)
+	check("events after table", config.QuerySortDate, config.QueryOrderAsc, config.QueryDisplayShow)
+
+	bySize := serve("/a?s/b", config.FolderSettings{}, nil)
+	serve("/manuals", manuals, bySize)
+	check("manuals after size", config.QuerySortName, config.QueryOrderAsc, config.QueryDisplayShow)
+	serve("/b", config.FolderSettings{}, bySize)
+	check("folder after size", config.QuerySortSize, config.QueryOrderDesc, config.QueryDisplayShow)
+
+	// Choosing a display in a folder with its own sort keeps the earlier sort
+	recursive := serve("/manuals?y/r/o/a/s/n", manuals, bySize)
+	if len(recursive) != 1 {
+		t.Fatalf("preferences are not saved: %v", recursive)
+	}
+	serve("/b", config.FolderSettings{}, recursive)
+	check("folder after manuals", config.QuerySortSize, config.QueryOrderDesc, config.QueryDisplayRecursive)
+	serve("/events", events, recursive)
+	check("events after manuals", config.QuerySortDate, config.QueryOrderAsc, config.QueryDisplayShow)
+}
+
+func Test_columnLinks(t *testing.T) {
+	opts := config.RequestSettings{Sort: config.QuerySortSize, Order: config.QueryOrderDesc,
+		Display: config.QueryDisplayTable}
+	links := columnLinks(opts)
+	urls := make(map[string]string)
+	for _, link := range links {
+		urls[link.Title] = link.Url
+		if link.Current != (link.Title == "size") {
+			t.Errorf("column %v is current: %v", link.Title, link.Current)
+		}
+	}
+	want := map[string]string{
+		"size": "?y/t/o/a/s/b",
+		"name": "?y/t/o/z/s/n",
+	}
+	for title, url := range want {
+		if urls[title] != url {
+			t.Errorf("column %v links to %v, want %v", title, urls[title], url)
+		}
+	}
+}
+
+func Test_zipEntries(t *testing.T) {
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = afero.NewMemMapFs()
+	for name, data := range map[string]string{
+		"/zipped/a.jpg":                        "a",
+		"/zipped/notes.txt":                    "not media",
+		"/zipped/.hidden.jpg":                  "hidden",
+		"/zipped/sub/b.png":                    "bb",
+		"/zipped/private/_foldergal.yaml":      "download: false",
+		"/zipped/private/c.jpg":                "c",
+		"/zipped/private/open/_foldergal.yaml": "download: true",
+		"/zipped/private/open/d.jpg":           "d",
+	} {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		recursive bool
+		want      []string
+	}{
+		{false, []string{"a.jpg"}},
+		{true, []string{"a.jpg", "sub/b.png"}},
+	}
+	for _, tc := range tests {
+		var names []string
+		for _, entry := range zipEntries("/zipped", tc.recursive, config.NewFolderAccess("")) {
+			names = append(names, entry.Name)
+		}
+		if !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("zipEntries(recursive %v) = %v, want %v", tc.recursive, names, tc.want)
+		}
+	}
+}
+
+func Test_selectedZipEntries(t *testing.T) {
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = afero.NewMemMapFs()
+	for name, data := range map[string]string{
+		"/picked/a.jpg":                   "a",
+		"/picked/notes.txt":               "not media",
+		"/picked/sub/b.png":               "bb",
+		"/picked/sub/deeper/c.jpg":        "c",
+		"/picked/private/_foldergal.yaml": "download: false",
+		"/picked/private/d.jpg":           "d",
+		"/other/e.jpg":                    "e",
+	} {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		paths []string
+		want  []string // Names, nil for an error
+	}{
+		{[]string{"/picked/a.jpg", "picked/sub"}, []string{"a.jpg", "sub/b.png", "sub/deeper/c.jpg"}},
+		{[]string{"picked/sub/b.png", "/picked/sub"}, []string{"sub/b.png", "sub/deeper/c.jpg"}},
+		{[]string{"/picked/../other/e.jpg"}, nil},
+		{[]string{"/other/e.jpg"}, nil},
+		{[]string{"/picked/.hidden.jpg"}, nil},
+		{[]string{"/picked/notes.txt"}, nil},
+		{[]string{"/picked/missing.jpg"}, nil},
+		{[]string{"/picked/private/d.jpg"}, nil},
+		{[]string{"/picked/private"}, nil},
+		{nil, nil},
+	}
+	for _, tc := range tests {
+		entries, err := selectedZipEntries("/picked", tc.paths, config.NewFolderAccess(""))
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		if (err != nil) != (tc.want == nil) || !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("selectedZipEntries(%v) = %v, %v, want %v", tc.paths, names, err, tc.want)
+		}
+	}
+}
+
+func Test_sessionUser(t *testing.T) {
+	saved := config.Global
+	defer func() { config.Global = saved }()
+	config.Global.Secret = "test"
+	config.Global.Htpasswd = filepath.Join(t.TempDir(), "htpasswd")
+	// Sessions check only that the user is still there, not the hash
+	users := []byte("ann:$2a$04$invalidhashinvalidhashinvalidhashinvalidhashinval\n")
+	if err := os.WriteFile(config.Global.Htpasswd, users, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{newSession("ann", later), "ann"},
+		{newSession("ann", time.Now().Add(-time.Minute)), ""},
+		{newSession("bob", later), ""},
+		{strings.Replace(newSession("ann", later), ".", ".9", 1), ""},
+		{"616e6e", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if user, ok := sessionUser(tc.value); user != tc.want || ok != (tc.want != "") {
+			t.Errorf("sessionUser(%q) = %q, %v, want %q", tc.value, user, ok, tc.want)
+		}
+	}
+}
+
+func Test_loginNext(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"/trip?s/n", "/trip?s/n"},
+		{"//evil.example/", "/"},
+		{"https://evil.example/", "/"},
+		{"/\\evil.example/", "/"},
+		{"/\t/evil.example/", "/"},
+		{"/\n/evil.example/", "/"},
+		{"/trip\r\nSet-Cookie: a=b", "/"},
+		{"", "/"},
+	}
+	for _, tc := range tests {
+		if result := loginNext(tc.next); result != tc.want {
+			t.Errorf("loginNext(%q) = %q, want %q", tc.next, result, tc.want)
+		}
+	}
+}
+
+func Test_parseShareDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"7d", 7 * 24 * time.Hour, true},
+		{"36h", 36 * time.Hour, true},
+		{"90m", 90 * time.Minute, true},
+		{"366d", maxShareDuration, true},
+		{"367d", 0, false},
+		{"99999999999d", 0, false},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"week", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range tests {
+		result, err := parseShareDuration(tc.value)
+		if result != tc.want || (err == nil) != tc.ok {
+			t.Errorf("parseShareDuration(%q) = %v, %v, want %v", tc.value, result, err, tc.want)
+		}
+	}
+}
+
+// Serves requests through the middleware of the server from a gallery of
+// files in memory, where ann can log in with the password "secret"
+func serveGallery(t *testing.T, files map[string]string) func(*http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	root, saved, savedLogger := storage.Root, config.Global, logger
+	t.Cleanup(func() { storage.Root, config.Global, logger = root, saved, savedLogger })
+	storage.Root = afero.NewMemMapFs()
+	for name, data := range files {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logger = log.New(io.Discard, "", 0)
+	config.Global.Secret = "test"
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Global.Htpasswd = filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := os.WriteFile(config.Global.Htpasswd, []byte("ann:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	handler := authHandler(accessHandler(metadataHandler(paramHandler(http.HandlerFunc(HttpHandler)))))
+	return func(request *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+}
+
+// Password hashed with bcrypt for folder settings
+func folderPassword(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func Test_viewHandlerJson(t *testing.T) {
+	hash := folderPassword(t, "open sesame")
+	serve := serveGallery(t, map[string]string{
+		"/club/_foldergal.yaml": "access: [ann]\npassword: '" + hash + "'\nignore: [private/]\n",
+		"/club/a.jpg":           "a",
+	})
+	request := httptest.NewRequest(http.MethodGet, "/club/a.jpg?json", http.NoBody)
+	request.AddCookie(&http.Cookie{Name: sessionCookie,
+		Value: newSession("ann", time.Now().Add(time.Hour))})
+	request.AddCookie(&http.Cookie{Name: unlockCookieName("/club"),
+		Value: config.Sign("unlock", "/club", hash)})
+	response := serve(request)
+	assertStatus(t, response.Code, http.StatusOK)
+	body := response.Body.String()
+	for _, secret := range []string{"password", hash, "access", "ann", "private/"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("media JSON shows %q: %s", secret, body)
+		}
+	}
+}
+
+func Test_settingsFolder(t *testing.T) {
+	tests := []struct {
+		reqPath string
+		up      int
+		want    string
+	}{
+		{"/a/b/c.jpg", 0, "/a/b"},
+		{"/a/b/c.jpg", 1, "/a"},
+		{"/a/b/c.jpg", 5, "/"},
+		{"/nonexistent", math.MaxInt, "/"},
+	}
+	for _, tc := range tests {
+		if result := settingsFolder(tc.reqPath, tc.up); result != tc.want {
+			t.Errorf("settingsFolder(%q, %v) = %q, want %q", tc.reqPath, tc.up, result, tc.want)
+		}
+	}
+	if result := levelsAbove("/a/b/c.jpg"); result != 2 {
+		t.Errorf("levelsAbove() = %v, want 2", result)
+	}
+}
+
+func Test_viewHandlerUp(t *testing.T) {
+	serve := serveGallery(t, map[string]string{"/trip/day1/a.jpg": "a"})
+	request := httptest.NewRequest(http.MethodGet,
+		"/trip/day1/a.jpg?y/r/u/9223372036854775807/json", http.NoBody)
+	request.AddCookie(&http.Cookie{Name: sessionCookie,
+		Value: newSession("ann", time.Now().Add(time.Hour))})
+	response := serve(request)
+	assertStatus(t, response.Code, http.StatusOK)
+	if body := response.Body.String(); !strings.Contains(body, `"parent":"/?`) {
+		t.Errorf("media far up is not listed in the root: %s", body)
+	}
+}
+
+func Test_sortLinksCustom(t *testing.T) {
+	opts := config.RequestSettings{Sort: config.QuerySortDate, Order: config.QueryOrderDesc}
+	for _, link := range sortLinks(opts, true) {
+		if link.Title == "custom" && !strings.Contains(link.Url, "o/a") {
+			t.Errorf("custom sort links to %v, not in ascending order", link.Url)
+		}
+	}
+}
+
+func Test_accessMiddleware(t *testing.T) {
+	hash := folderPassword(t, "open sesame")
+	serve := serveGallery(t, map[string]string{
+		"/family/_foldergal.yaml": "access: [bob]",
+		"/family/a.jpg":           "a",
+		"/open/_foldergal.yaml":   "access: [ann, bob]",
+		"/open/b.jpg":             "b",
+		"/club/_foldergal.yaml":   "password: '" + hash + "'",
+		"/club/c.jpg":             "c",
+		"/trip/day1/d.jpg":        "d",
+		"/trip/day2/e.jpg":        "e",
+	})
+	session := &http.Cookie{Name: sessionCookie,
+		Value: newSession("ann", time.Now().Add(time.Hour))}
+	unlock := &http.Cookie{Name: unlockCookieName("/club"),
+		Value: config.Sign("unlock", "/club", hash)}
+	token := config.ShareToken("/trip/day1", time.Now().Add(time.Hour))
+	share := &http.Cookie{Name: shareCookie + config.ShareId(token), Value: token}
+
+	tests := []struct {
+		name     string
+		target   string
+		cookies  []*http.Cookie
+		status   int
+		contains string
+	}{
+		{"anonymous", "/open", nil, http.StatusUnauthorized, `action="/?login"`},
+		{"anonymous static", "/?static/ui.svg", nil, http.StatusOK, ""},
+		{"user", "/open", []*http.Cookie{session}, http.StatusOK, "b.jpg"},
+		{"outside the access", "/family", []*http.Cookie{session}, http.StatusNotFound, ""},
+		{"media outside the access", "/family/a.jpg", []*http.Cookie{session}, http.StatusNotFound, ""},
+		{"hidden in lists", "/?json", []*http.Cookie{session}, http.StatusOK, `"open"`},
+		{"locked", "/club/c.jpg", []*http.Cookie{session}, http.StatusUnauthorized, `action="/club?unlock"`},
+		{"unlocked", "/club/c.jpg", []*http.Cookie{session, unlock}, http.StatusOK, ""},
+		{"shared", "/trip/day1/d.jpg", []*http.Cookie{share}, http.StatusOK, ""},
+		{"shared list", "/trip/day1?json", []*http.Cookie{share}, http.StatusOK, "d.jpg"},
+		{"not shared", "/trip/day2/e.jpg", []*http.Cookie{share}, http.StatusUnauthorized, ""},
+		{"not shared above", "/trip", []*http.Cookie{share}, http.StatusUnauthorized, ""},
+		{"shared route only", "/trip/day1?zip", []*http.Cookie{share}, http.StatusUnauthorized, ""},
+		{"share link", "/trip/day1?share/" + token, nil, http.StatusSeeOther, ""},
+		{"tampered share link", "/trip/day1?share/" + token[:len(token)-1], nil, http.StatusGone, ""},
+	}
+	for _, tc := range tests {
+		request := httptest.NewRequest(http.MethodGet, tc.target, http.NoBody)
+		for _, cookie := range tc.cookies {
+			request.AddCookie(cookie)
+		}
+		response := serve(request)
+		if response.Code != tc.status {
+			t.Errorf("%s: %s returns %d, want %d", tc.name, tc.target, response.Code, tc.status)
+		}
+		if body := response.Body.String(); !strings.Contains(body, tc.contains) {
+			t.Errorf("%s: %s does not show %q: %s", tc.name, tc.target, tc.contains, body)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/?json", http.NoBody)
+	request.AddCookie(session)
+	if body := serve(request).Body.String(); strings.Contains(body, "family") ||
+		strings.Contains(body, `"club"`) {
+		t.Errorf("list shows folders which ann cannot see: %s", body)
+	}
+
+	unlockWith := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}, "next": {"/club"}}
+		request := httptest.NewRequest(http.MethodPost, "/club?unlock",
+			strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(session)
+		return serve(request)
+	}
+	assertStatus(t, unlockWith("wrong").Code, http.StatusUnauthorized)
+	response := unlockWith("open sesame")
+	assertStatus(t, response.Code, http.StatusSeeOther)
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != unlock.Name || cookies[0].Value != unlock.Value {
+		t.Errorf("unlocking sets %v, want the cookie %v", cookies, unlock)
+	}
+}
+
+func Test_loginHandlerAttempts(t *testing.T) {
+	serve := serveGallery(t, map[string]string{"/a.jpg": "a"})
+	loginAttempts = attemptLimiter{failures: make(map[string]failedAttempts)}
+	logIn := func(password, addr string) *httptest.ResponseRecorder {
+		form := url.Values{"user": {"ann"}, "password": {password}, "next": {"/"}}
+		request := httptest.NewRequest(http.MethodPost, "/?login", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.RemoteAddr = addr
+		return serve(request)
+	}
+	for range freeAttempts {
+		assertStatus(t, logIn("wrong", "192.0.2.1:1234").Code, http.StatusUnauthorized)
+	}
+	response := logIn("secret", "192.0.2.1:5678")
+	assertStatus(t, response.Code, http.StatusTooManyRequests)
+	if retry := response.Header().Get("Retry-After"); retry != "1" {
+		t.Errorf("Retry-After = %q, want 1", retry)
+	}
+	assertStatus(t, logIn("secret", "198.51.100.1:1234").Code, http.StatusSeeOther)
+
+	loginAttempts.failures["192.0.2.1"] = failedAttempts{count: freeAttempts + 1,
+		last: time.Now().Add(-2 * attemptWait)}
+	assertStatus(t, logIn("secret", "192.0.2.1:1234").Code, http.StatusSeeOther)
+	if wait := loginAttempts.wait("192.0.2.1"); wait != 0 {
+		t.Errorf("wait after logging in = %v, want 0", wait)
+	}
+}
+
+func Test_attemptLimiter(t *testing.T) {
+	limiter := attemptLimiter{failures: make(map[string]failedAttempts)}
+	for range freeAttempts {
+		if wait := limiter.wait("a"); wait != 0 {
+			t.Fatalf("wait before %v failures = %v", freeAttempts, wait)
+		}
+		limiter.fail("a")
+	}
+	previous := limiter.wait("a")
+	for range 20 {
+		limiter.fail("a")
+		wait := limiter.wait("a")
+		if wait+time.Second < previous || wait > maxAttemptWait {
+			t.Errorf("wait = %v after %v, want more up to %v", wait, previous, maxAttemptWait)
+		}
+		previous = wait
+	}
+	limiter.failures["stale"] = failedAttempts{count: 100,
+		last: time.Now().Add(-3 * maxAttemptWait)}
+	limiter.fail("b")
+	if _, ok := limiter.failures["stale"]; ok {
+		t.Error("old failures are kept")
+	}
+}
+
+func Test_unlockHandlerAttempts(t *testing.T) {
+	serve := serveGallery(t, map[string]string{
+		"/club/_foldergal.yaml": "password: '" + folderPassword(t, "open sesame") + "'\n",
+		"/club/a.jpg":           "a",
+	})
+	unlockAttempts = attemptLimiter{failures: make(map[string]failedAttempts)}
+	unlockWith := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}, "next": {"/club"}}
+		request := httptest.NewRequest(http.MethodPost, "/club?unlock",
+			strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(&http.Cookie{Name: sessionCookie,
+			Value: newSession("ann", time.Now().Add(time.Hour))})
+		return serve(request)
+	}
+	for range freeAttempts {
+		assertStatus(t, unlockWith("wrong").Code, http.StatusUnauthorized)
+	}
+	response := unlockWith("open sesame")
+	assertStatus(t, response.Code, http.StatusTooManyRequests)
+	if response.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if cookies := response.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("unlocking while waiting sets %v", cookies)
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/goccy/go-yaml v1.17.1
 	github.com/kovidgoyal/imaging v1.6.4
 	github.com/spf13/afero v1.14.0
+	golang.org/x/crypto v0.37.0
 )
 
 require (
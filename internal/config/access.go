@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parses the lines group: user1 user2 of a groups file, like those of
+// Apache's AuthGroupFile
+func parseGroups(data []byte) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		group, users, ok := strings.Cut(text, ":")
+		if group = strings.TrimSpace(group); !ok || group == "" {
+			return groups, fmt.Errorf("line %d: invalid group", line)
+		}
+		groups[group] = append(groups[group], strings.Fields(users)...)
+	}
+	return groups, nil
+}
+
+// Users in each group of the groups file
+var groupsCache cachedFile[map[string][]string]
+
+// Groups reads the groups file, cached until it changes
+func Groups() (map[string][]string, error) {
+	if Global.Groups == "" {
+		return nil, nil
+	}
+	return groupsCache.read(Global.Groups, parseGroups)
+}
+
+// AccessAllowed checks if a user is one of the users or in one of the
+// @groups of the access rules of a folder. Folders without rules are open
+// to every visitor, those with rules to none who has not logged in.
+func AccessAllowed(access []string, user string) bool {
+	if len(access) == 0 {
+		return true
+	}
+	if user == "" {
+		return false
+	}
+	groups, _ := Groups()
+	for _, name := range access {
+		if group, ok := strings.CutPrefix(name, "@"); ok {
+			if slices.Contains(groups[group], user) {
+				return true
+			}
+		} else if name == user {
+			return true
+		}
+	}
+	return false
+}
+
+// FolderAccess tells which folders a user can see, remembering them
+// while serving a request
+type FolderAccess struct {
+	user       string
+	unlocked   func(folder, hash string) bool
+	shared     []string // Folders and files of share links
+	onlyShared bool
+	folders    map[string]bool
+}
+
+func NewFolderAccess(user string) *FolderAccess {
+	return &FolderAccess{user: user, folders: make(map[string]bool)}
+}
+
+// WithUnlocked sets how to tell if the visitor unlocked a folder with
+// a password, by the folder and the hash of its password
+func (fa *FolderAccess) WithUnlocked(unlocked func(folder, hash string) bool) *FolderAccess {
+	fa.unlocked = unlocked
+	return fa
+}
+
+// WithShared adds the folders and files of share links, which open without
+// the access rules and passwords above and of them
+func (fa *FolderAccess) WithShared(scopes ...string) *FolderAccess {
+	for _, scope := range scopes {
+		fa.shared = append(fa.shared, accessKey(scope))
+	}
+	return fa
+}
+
+// OnlyShared leaves out what share links do not open, for visitors who
+// did not log in
+func (fa *FolderAccess) OnlyShared() *FolderAccess {
+	fa.onlyShared = true
+	return fa
+}
+
+// Shared tells if a folder or file is the one of a share link
+func (fa *FolderAccess) Shared(relPath string) bool {
+	return slices.Contains(fa.shared, accessKey(relPath))
+}
+
+// Finds the highest shared folder of a folder or of the folders above it
+func (fa *FolderAccess) sharedScope(folder string) (string, bool) {
+	scope, found := "", false
+	for _, shared := range fa.shared {
+		if InScope(shared, folder) && (!found || len(shared) < len(scope)) {
+			scope, found = shared, true
+		}
+	}
+	return scope, found
+}
+
+// Access rules of a folder. Below a shared folder, those set above it no
+// longer apply.
+func (fa *FolderAccess) rules(folder string) []string {
+	if _, found := fa.sharedScope(folder); found {
+		own, _ := OwnFolderSettings(folder)
+		return own.Access
+	}
+	settings, _ := InheritedFolderSettings(folder)
+	return settings.Access
+}
+
+// Path of a folder from the root, like /a/b
+func accessKey(folderPath string) string {
+	return path.Clean("/" + strings.TrimPrefix(folderPath, "/"))
+}
+
+// Allows checks the access rules of a folder and of all its parents, so
+// subfolders can only narrow who sees them. Folders with passwords must
+// be unlocked too, unless they are shared.
+func (fa *FolderAccess) Allows(folderPath string) bool {
+	folder := accessKey(folderPath)
+	if allowed, ok := fa.folders[folder]; ok {
+		return allowed
+	}
+	_, inShared := fa.sharedScope(folder)
+	allowed := fa.Shared(folder)
+	if !allowed && (inShared || !fa.onlyShared) {
+		allowed = folder == "/" || fa.Allows(path.Dir(folder))
+		allowed = allowed && AccessAllowed(fa.rules(folder), fa.user) && !fa.isLocked(folder)
+	}
+	fa.folders[folder] = allowed
+	return allowed
+}
+
+// Checks if a folder has a password and was not unlocked
+func (fa *FolderAccess) isLocked(folder string) bool {
+	own, _ := OwnFolderSettings(folder)
+	return own.Password != "" && (fa.unlocked == nil || !fa.unlocked(folder, own.Password))
+}
+
+// LockedFolder finds the folder with a password which keeps the user from
+// seeing a folder, from the root or the shared folder down. It is empty
+// when the folder can be seen or when access rules hide it.
+func (fa *FolderAccess) LockedFolder(folderPath string) string {
+	target := accessKey(folderPath)
+	scope, shared := fa.sharedScope(target)
+	folder := "/"
+	for part := range strings.SplitSeq(target, "/") {
+		folder = path.Join(folder, part)
+		if shared && (folder == scope || !InScope(scope, folder)) {
+			continue
+		}
+		if !AccessAllowed(fa.rules(folder), fa.user) {
+			return ""
+		}
+		if fa.isLocked(folder) {
+			return folder
+		}
+	}
+	return ""
+}
+
+// CheckFolderPassword compares a password with the bcrypt hash of the
+// password of a folder
+func CheckFolderPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// AllowsPath checks a folder, or the folder of a file unless the file is
+// shared
+func (fa *FolderAccess) AllowsPath(relPath string, isDir bool) bool {
+	if !isDir {
+		if fa.Shared(relPath) {
+			return true
+		}
+		relPath = path.Dir(path.Clean("/" + relPath))
+	}
+	return fa.Allows(relPath)
+}
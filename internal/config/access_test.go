@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/bcrypt"
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+func TestParseGroups(t *testing.T) {
+	data := "# groups\nfamily: ann bob\n\nclients:cid\nfamily: dan\nempty:\n"
+	groups, err := parseGroups([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"family": {"ann", "bob", "dan"},
+		"clients": {"cid"}, "empty": nil}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("parseGroups() = %v, want %v", groups, want)
+	}
+	if _, err := parseGroups([]byte("family ann")); err == nil {
+		t.Error("parseGroups() has no error for a line without a group")
+	}
+}
+
+func TestFolderAccess(t *testing.T) {
+	root, saved := storage.Root, Global
+	defer func() { storage.Root, Global = root, saved }()
+	storage.Root = afero.NewMemMapFs()
+	Global.Groups = filepath.Join(t.TempDir(), "groups")
+	if err := os.WriteFile(Global.Groups, []byte("family: ann bob\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"/home/_foldergal.yaml":        "access: ['@family']",
+		"/home/bob/_foldergal.yaml":    "access: [bob, cid]",
+		"/home/shared/_foldergal.yaml": "reset: [access]",
+		"/clients/_foldergal.yaml":     "access: [cid]",
+		"/public/_foldergal.yaml":      "description: Open",
+	}
+	for name, data := range files {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		user   string
+		folder string
+		want   bool
+	}{
+		{"", "/", true},
+		{"", "public", true},
+		{"", "/home", false},
+		{"ann", "/home", true},
+		{"ann", "/home/photos/2024", true},
+		{"ann", "/home/bob", false},
+		{"bob", "/home/bob", true},
+		{"cid", "/home/bob", false}, // Not in the parent
+		{"cid", "/home/shared", false},
+		{"dan", "/home/shared", false},
+		{"bob", "/home/shared", true},
+		{"cid", "/clients", true},
+		{"ann", "/clients/acme", false},
+	}
+	for _, tc := range tests {
+		access := NewFolderAccess(tc.user)
+		if result := access.Allows(tc.folder); result != tc.want {
+			t.Errorf("Allows(%q) for %q = %v, want %v", tc.folder, tc.user, result, tc.want)
+		}
+	}
+	if access := NewFolderAccess("ann"); access.AllowsPath("home/bob/a.jpg", false) ||
+		!access.AllowsPath("home/a.jpg", false) {
+		t.Error("AllowsPath() does not check the folder of files")
+	}
+}
+
+func TestFolderAccessPassword(t *testing.T) {
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = afero.NewMemMapFs()
+	hash, err := bcrypt.GenerateFromPassword([]byte("open sesame"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"/clients/acme/_foldergal.yaml":       "password: '" + string(hash) + "'",
+		"/clients/acme/draft/_foldergal.yaml": "password: '" + string(hash) + "'",
+		"/clients/_foldergal.yaml":            "access: [cid]",
+	}
+	for name, data := range files {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		user     string
+		unlocked []string
+		folder   string
+		allowed  bool
+		locked   string
+	}{
+		{"cid", nil, "/clients", true, ""},
+		{"cid", nil, "/clients/acme", false, "/clients/acme"},
+		{"cid", nil, "/clients/acme/draft/v1", false, "/clients/acme"},
+		{"cid", []string{"/clients/acme"}, "/clients/acme/final", true, ""},
+		{"cid", []string{"/clients/acme"}, "/clients/acme/draft", false, "/clients/acme/draft"},
+		{"ann", nil, "/clients/acme", false, ""}, // Hidden by the access rules
+	}
+	for _, tc := range tests {
+		access := NewFolderAccess(tc.user).WithUnlocked(func(folder, h string) bool {
+			return h == string(hash) && slices.Contains(tc.unlocked, folder)
+		})
+		if result := access.Allows(tc.folder); result != tc.allowed {
+			t.Errorf("Allows(%q) unlocking %v = %v, want %v", tc.folder, tc.unlocked, result, tc.allowed)
+		}
+		if result := access.LockedFolder(tc.folder); result != tc.locked {
+			t.Errorf("LockedFolder(%q) unlocking %v = %q, want %q", tc.folder, tc.unlocked, result, tc.locked)
+		}
+	}
+	if !CheckFolderPassword(string(hash), "open sesame") || CheckFolderPassword(string(hash), "open") ||
+		CheckFolderPassword("plain", "plain") {
+		t.Error("CheckFolderPassword() does not check the bcrypt hash")
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// Album of media from a folder and its subfolders, in the order of the
+// paths and glob patterns of its items, relative to the folder
+type AlbumSettings struct {
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Items       []string `json:"items,omitempty"`
+}
+
+// Extensions of album files in Configuration.AlbumsDir
+var albumExtensions = []string{".yaml", ".yml"}
+
+// Reads the album files in the albums folder by their names without
+// extensions
+func readAlbumsDir() (map[string]AlbumSettings, error) {
+	albums := make(map[string]AlbumSettings)
+	if Global.AlbumsDir == "" {
+		return albums, nil
+	}
+	entries, err := os.ReadDir(Global.AlbumsDir)
+	if err != nil {
+		return albums, err
+	}
+	var errs []error
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || !slices.Contains(albumExtensions, strings.ToLower(ext)) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(Global.AlbumsDir, entry.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var album AlbumSettings
+		if err := yaml.Unmarshal(data, &album); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		albums[strings.TrimSuffix(entry.Name(), ext)] = album
+	}
+	return albums, errors.Join(errs...)
+}
+
+// Albums of a folder by their names in lower case. The root folder also
+// has the albums from the albums folder, which win over those of its
+// settings.
+func FolderAlbums(folderPath string) (map[string]AlbumSettings, error) {
+	albums := make(map[string]AlbumSettings)
+	settings, err := OwnFolderSettings(folderPath)
+	for name, album := range settings.Albums {
+		albums[strings.ToLower(name)] = album
+	}
+	if strings.Trim(filepath.ToSlash(folderPath), "/.") == "" {
+		dirAlbums, dirErr := readAlbumsDir()
+		for name, album := range dirAlbums {
+			albums[strings.ToLower(name)] = album
+		}
+		err = errors.Join(err, dirErr)
+	}
+	return albums, err
+}
@@ -2,7 +2,13 @@ package config
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"specto.org/projects/foldergal/internal/storage"
 
@@ -11,9 +17,138 @@ import (
 
 var MetafileName = "_foldergal.yaml"
 
+// Settings of a folder from its settings file. Subfolders inherit them,
+// except for the files, sequence, pinned, albums and password, unless they
+// set or reset them. Passwords apply to subfolders through FolderAccess.
 type FolderSettings struct {
-	Description string
-	Copyright   string
+	Description string `json:"description,omitempty"`
+	Copyright   string `json:"copyright,omitempty"`
+	// Extensions grouped by base name; overrides Configuration.GroupExtensions
+	Group []string `json:"group,omitempty"`
+	// Descriptions of files in the folder by their names
+	Files map[string]FileSettings `json:"files,omitempty"`
+	// Defaults of the list when the query does not set them
+	Sort    string          `json:"sort,omitempty"`
+	Order   string          `json:"order,omitempty"`
+	Display string          `json:"display,omitempty"`
+	Filter  *FilterSettings `json:"filter,omitempty"`
+	// Names of files and folders in the custom sort, the rest come after them
+	Sequence []string `json:"sequence,omitempty"`
+	// Names of featured files and folders shown first in any sort
+	Pinned []string `json:"pinned,omitempty"`
+	// Albums of media from the folder and its subfolders by their names
+	Albums map[string]AlbumSettings `json:"albums,omitempty"`
+	// Whether the folder and its subfolders can be downloaded as ZIP files
+	Download *bool `json:"download,omitempty"`
+	// Users and @groups who can see the folder and its subfolders
+	Access []string `json:"access,omitempty"`
+	// Bcrypt hash of the password which unlocks the folder and its subfolders
+	Password string `json:"password,omitempty"`
+	// Inherited settings which are not used, "all" for every one of them
+	Reset []string `json:"reset,omitempty"`
+	// Patterns of hidden files and folders like in .foldergalignore files.
+	// Subfolders get them as rules, not as settings.
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+type FilterSettings struct {
+	Rating  int      `json:"rating,omitempty"`
+	Keyword string   `json:"keyword,omitempty"`
+	Media   []string `json:"media,omitempty"`
+}
+
+// Names of list settings in folder settings files
+var (
+	sortNames = map[string]QTypeSort{
+		"name": QuerySortName, "date": QuerySortDate, "size": QuerySortSize,
+		"type": QuerySortType, "dimensions": QuerySortPixels,
+		"duration": QuerySortDuration, "shuffle": QuerySortShuffle,
+		"custom": QuerySortCustom,
+	}
+	orderNames = map[string]QTypeOrder{
+		"asc": QueryOrderAsc, "desc": QueryOrderDesc,
+	}
+	displayNames = map[string]QTypeDisplay{
+		"folder": QueryDisplayShow, "all": QueryDisplayRecursive,
+		"table": QueryDisplayTable,
+	}
+	classNames = map[string]QTypeClass{
+		"image": QueryClassImage, "video": QueryClassVideo,
+		"audio": QueryClassAudio, "pdf": QueryClassPdf,
+	}
+)
+
+// Defaults of the list of the folder, unknown names are left out
+func (fs FolderSettings) RequestDefaults() RequestSettings {
+	opts := NewRequestSettings()
+	if sort, ok := sortNames[strings.ToLower(fs.Sort)]; ok {
+		opts.Sort = sort
+		opts.Order = DefaultOrder(sort)
+	}
+	if order, ok := orderNames[strings.ToLower(fs.Order)]; ok {
+		opts.Order = order
+	}
+	if display, ok := displayNames[strings.ToLower(fs.Display)]; ok {
+		opts.Display = display
+	}
+	if fs.Filter != nil {
+		opts.Rating = max(0, min(fs.Filter.Rating, MaxRating))
+		opts.Keyword = strings.ToLower(strings.TrimSpace(fs.Filter.Keyword))
+		var classes string
+		for _, name := range fs.Filter.Media {
+			classes += string(classNames[strings.ToLower(name)])
+		}
+		opts.Classes = normalizeClasses(classes)
+	}
+	return opts
+}
+
+// Tells which of the sort, order and display the settings file chooses
+func (fs FolderSettings) choosesList() (sort, order, display bool) {
+	_, sort = sortNames[strings.ToLower(fs.Sort)]
+	_, order = orderNames[strings.ToLower(fs.Order)]
+	_, display = displayNames[strings.ToLower(fs.Display)]
+	return
+}
+
+// UserPreferences leaves out of the preferences of a user what the settings
+// file chooses, since it has the last word in the folder. The order of the
+// user goes with their sort.
+func (fs FolderSettings) UserPreferences(prefs RequestSettings) RequestSettings {
+	sort, order, display := fs.choosesList()
+	if sort {
+		prefs.Sort, prefs.Order = "", ""
+	}
+	if order {
+		prefs.Order = ""
+	}
+	if display {
+		prefs.Display = ""
+	}
+	return prefs
+}
+
+// SavedPreferences are the preferences to keep after a user chose some in
+// the folder. What the settings file chooses was not the user's choice, so
+// the earlier preferences remain for it.
+func (fs FolderSettings) SavedPreferences(chosen, earlier RequestSettings) RequestSettings {
+	sort, order, display := fs.choosesList()
+	if sort {
+		chosen.Sort, chosen.Order = earlier.Sort, earlier.Order
+	}
+	if order {
+		chosen.Order = earlier.Order
+	}
+	if display {
+		chosen.Display = earlier.Display
+	}
+	return chosen
+}
+
+type FileSettings struct {
+	Title   string `json:"title,omitempty"`
+	Caption string `json:"caption,omitempty"`
+	Alt     string `json:"alt,omitempty"`
 }
 
 func ReadFolderSettings(path string) (FolderSettings, error) {
@@ -42,6 +177,149 @@ func ReadFolderSettings(path string) (FolderSettings, error) {
 	return fs, nil
 }
 
+// Removes an inherited setting by its name in settings files
+func (fs FolderSettings) without(key string) FolderSettings {
+	switch strings.ToLower(key) {
+	case "all":
+		return FolderSettings{}
+	case "description":
+		fs.Description = ""
+	case "copyright":
+		fs.Copyright = ""
+	case "group":
+		fs.Group = nil
+	case "sort":
+		fs.Sort = ""
+	case "order":
+		fs.Order = ""
+	case "display":
+		fs.Display = ""
+	case "filter":
+		fs.Filter = nil
+	case "download":
+		fs.Download = nil
+	case "access":
+		fs.Access = nil
+	}
+	return fs
+}
+
+// Applies the settings of a folder over those of its parent.
+// An order is inherited only with the sort it was set for.
+func (fs FolderSettings) inherit(parent FolderSettings) FolderSettings {
+	for _, key := range fs.Reset {
+		parent = parent.without(key)
+	}
+	merged := parent
+	merged.Files = fs.Files
+	merged.Sequence = fs.Sequence
+	merged.Pinned = fs.Pinned
+	merged.Albums = fs.Albums
+	merged.Reset = fs.Reset
+	merged.Ignore = fs.Ignore
+	merged.Password = fs.Password
+	if fs.Description != "" {
+		merged.Description = fs.Description
+	}
+	if fs.Copyright != "" {
+		merged.Copyright = fs.Copyright
+	}
+	if fs.Group != nil {
+		merged.Group = fs.Group
+	}
+	if fs.Sort != "" {
+		merged.Sort = fs.Sort
+		merged.Order = ""
+	}
+	if fs.Order != "" {
+		merged.Order = fs.Order
+	}
+	if fs.Display != "" {
+		merged.Display = fs.Display
+	}
+	if fs.Filter != nil {
+		merged.Filter = fs.Filter
+	}
+	if fs.Download != nil {
+		merged.Download = fs.Download
+	}
+	if fs.Access != nil {
+		merged.Access = fs.Access
+	}
+	return merged
+}
+
+// Settings shown to visitors, without who can see the folder nor the
+// patterns of what is hidden in it
+func (fs FolderSettings) Public() FolderSettings {
+	fs.Access = nil
+	fs.Password = ""
+	fs.Ignore = nil
+	fs.Reset = nil
+	return fs
+}
+
+// Whether the folder can be downloaded, by default it can
+func (fs FolderSettings) DownloadAllowed() bool {
+	return fs.Download == nil || *fs.Download
+}
+
+type cachedFolderSettings struct {
+	modTime  time.Time
+	size     int64
+	settings FolderSettings
+	err      error
+}
+
+var (
+	settingsCache   = make(map[string]cachedFolderSettings)
+	settingsCacheMu sync.RWMutex
+)
+
+// OwnFolderSettings reads the settings file of a folder, cached until it
+// changes. Folders without one have empty settings.
+func OwnFolderSettings(folderPath string) (FolderSettings, error) {
+	stat, err := storage.Root.Stat(filepath.Join(folderPath, MetafileName))
+	if err != nil || stat.IsDir() {
+		return FolderSettings{}, nil
+	}
+	settingsCacheMu.RLock()
+	cached, ok := settingsCache[folderPath]
+	settingsCacheMu.RUnlock()
+	if ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.settings, cached.err
+	}
+	fs, err := ReadFolderSettings(folderPath)
+	settingsCacheMu.Lock()
+	settingsCache[folderPath] = cachedFolderSettings{
+		modTime: stat.ModTime(), size: stat.Size(), settings: fs, err: err}
+	settingsCacheMu.Unlock()
+	return fs, err
+}
+
+// InheritedFolderSettings merges the settings files from the root folder
+// down to the folder. Errors of files are returned after reading the others.
+func InheritedFolderSettings(folderPath string) (FolderSettings, error) {
+	var errs []error
+	folder := "/"
+	merged, err := OwnFolderSettings(folder)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", folder, err))
+	}
+	for part := range strings.SplitSeq(strings.Trim(filepath.ToSlash(folderPath), "/"), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		folder = path.Join(folder, part)
+		fs, err := OwnFolderSettings(folder)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", folder, err))
+		}
+		merged = fs.inherit(merged)
+	}
+	return merged, errors.Join(errs...)
+}
+
 func HasFolderSettings(path string) bool {
 	file := filepath.Join(path, MetafileName)
 	if file, err := storage.Root.Stat(file); err != nil || file.IsDir() {
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"specto.org/projects/foldergal/internal/storage"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/spf13/afero"
+)
+
+func TestRequestDefaults(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want RequestSettings
+	}{
+		{"", NewRequestSettings()},
+		{"sort: name", RequestSettings{Sort: QuerySortName, Order: QueryOrderAsc,
+			Display: QueryDisplayShow}},
+		{"sort: Date\norder: asc", RequestSettings{Sort: QuerySortDate,
+			Order: QueryOrderAsc, Display: QueryDisplayShow}},
+		{"sort: nope\norder: up\ndisplay: all", RequestSettings{Sort: QuerySortDate,
+			Order: QueryOrderDesc, Display: QueryDisplayRecursive}},
+		{"filter:\n  rating: 7\n  keyword: Sea\n  media: [video, image, photo]",
+			RequestSettings{Sort: QuerySortDate, Order: QueryOrderDesc,
+				Display: QueryDisplayShow, Rating: MaxRating, Keyword: "sea", Classes: "iv"}},
+	}
+	for _, tc := range tests {
+		var fs FolderSettings
+		if err := yaml.Unmarshal([]byte(tc.yaml), &fs); err != nil {
+			t.Fatalf("yaml %q: %v", tc.yaml, err)
+		}
+		if result := fs.RequestDefaults(); result != tc.want {
+			t.Errorf("RequestDefaults(%q) = %+v, want %+v", tc.yaml, result, tc.want)
+		}
+	}
+}
+
+func TestInheritedFolderSettings(t *testing.T) {
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = afero.NewMemMapFs()
+	files := map[string]string{
+		"/_foldergal.yaml":              "copyright: Me\nsort: date\norder: asc\nfiles:\n  a.jpg: {title: A}",
+		"/clients/_foldergal.yaml":      "description: Clients\nfilter: {rating: 2}",
+		"/clients/acme/_foldergal.yaml": "sort: name\nreset: [filter]\ndownload: false",
+		"/other/_foldergal.yaml":        "reset: [all]\ndisplay: all",
+	}
+	for name, data := range files {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = storage.Root.MkdirAll("/clients/acme/2024/shoot1", 0o755)
+	noDownload := false
+	tests := []struct {
+		folder string
+		want   FolderSettings
+	}{
+		{"/", FolderSettings{Copyright: "Me", Sort: "date", Order: "asc",
+			Files: map[string]FileSettings{"a.jpg": {Title: "A"}}}},
+		{"/clients", FolderSettings{Copyright: "Me", Description: "Clients",
+			Sort: "date", Order: "asc", Filter: &FilterSettings{Rating: 2}}},
+		{"/clients/acme/2024/shoot1", FolderSettings{Copyright: "Me",
+			Description: "Clients", Sort: "name", Download: &noDownload}},
+		{"other", FolderSettings{Display: "all", Reset: []string{"all"}}},
+		{"/missing/folder", FolderSettings{Copyright: "Me", Sort: "date", Order: "asc"}},
+	}
+	for _, tc := range tests {
+		result, err := InheritedFolderSettings(tc.folder)
+		if err != nil {
+			t.Errorf("InheritedFolderSettings(%q) error: %v", tc.folder, err)
+		}
+		if !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("InheritedFolderSettings(%q) = %+v, want %+v", tc.folder, result, tc.want)
+		}
+	}
+
+	_ = afero.WriteFile(storage.Root, "/clients/_foldergal.yaml", []byte("sort: [invalid"), 0o644)
+	if _, err := InheritedFolderSettings("/clients/acme"); err == nil {
+		t.Error("InheritedFolderSettings() of an invalid file, want an error")
+	}
+}
+
+func TestPublic(t *testing.T) {
+	settings := FolderSettings{Description: "Trip", Access: []string{"ann"},
+		Password: "$2y$05$abc", Ignore: []string{"private/"}, Reset: []string{"access"}}
+	want := FolderSettings{Description: "Trip"}
+	if result := settings.Public(); !reflect.DeepEqual(result, want) {
+		t.Errorf("Public() = %+v, want %+v", result, want)
+	}
+}
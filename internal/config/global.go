@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,6 +20,7 @@ type Configuration struct {
 	Host              string
 	Home              string
 	Root              string
+	AlbumsDir         string // Album files of the root folder
 	Cache             string `json:"-"`
 	Prefix            string
 	TlsCrt            string
@@ -27,9 +29,16 @@ type Configuration struct {
 	NotifyAfter       JsonDuration
 	DiscordName       string
 	DiscordWebhook    string
+	DiscordUser       string // Whose token opens thumbnails in notifications
+	Htpasswd          string // Users who can log in, all visitors when empty
+	Groups            string // Users in groups of folder access rules
+	Secret            string // Signs sessions and share links, random on each start when empty
+	RevokedShares     string // Ids of share links which no longer open
 	PublicHost        string
 	Copyright         string
 	Ffmpeg            string
+	GroupExtensions   JsonList
+	Ignore            JsonList
 	ConfigFile        string `json:"-"`
 	PublicUrl         string `json:"-"`
 	TimeZone          string
@@ -38,8 +47,12 @@ type Configuration struct {
 	Port              int
 	ThumbWidth        int
 	ThumbHeight       int
+	PageSize          int
+	ZipMaxSize        int // MiB of folders downloaded as ZIP files
 	Quiet             bool
 	Http2             bool
+	NotifyMemories    bool
+	FeedTokens        bool // Feeds and thumbnails open with the token of a user
 }
 
 // Loads configuration from json file
@@ -61,6 +74,7 @@ func (c *Configuration) LoadEnv(execFolder string) {
 	c.Port = intFromEnv("PORT", 8080)
 	c.Home = strFromEnv("HOME", "")
 	c.Root = strFromEnv("ROOT", execFolder)
+	c.AlbumsDir = strFromEnv("ALBUMS_DIR", "")
 	c.Prefix = strFromEnv("PREFIX", "")
 	c.TlsCrt = strFromEnv("TLS_CRT", "")
 	c.TlsKey = strFromEnv("TLS_KEY", "")
@@ -69,14 +83,29 @@ func (c *Configuration) LoadEnv(execFolder string) {
 	c.NotifyAfter = durationFromEnv("NOTIFY_AFTER", JsonDuration(30*time.Second))
 	c.DiscordWebhook = strFromEnv("DISCORD_WEBHOOK", "")
 	c.DiscordName = strFromEnv("DISCORD_NAME", "Gallery")
+	c.NotifyMemories = boolFromEnv("NOTIFY_MEMORIES", false)
+	c.DiscordUser = strFromEnv("DISCORD_USER", "")
+	c.Htpasswd = strFromEnv("HTPASSWD", "")
+	c.Groups = strFromEnv("GROUPS", "")
+	c.Secret = strFromEnv("SECRET", "")
+	c.RevokedShares = strFromEnv("REVOKED_SHARES", "")
+	c.FeedTokens = boolFromEnv("FEED_TOKENS", false)
 	c.PublicHost = strFromEnv("PUBLIC_HOST", "")
 	c.Quiet = boolFromEnv("QUIET", false)
 	c.ConfigFile = strFromEnv("CONFIG", "")
 	c.ThumbWidth = intFromEnv("THUMB_WIDTH", 400)
 	c.ThumbHeight = intFromEnv("THUMB_HEIGHT", 400)
+	c.PageSize = intFromEnv("PAGE_SIZE", 200)
+	c.ZipMaxSize = intFromEnv("ZIP_MAX_SIZE", 2048)
 	c.Copyright = strFromEnv("COPYRIGHT", "")
+	c.GroupExtensions = listFromEnv("GROUP_EXTENSIONS", DefaultGroupExtensions)
+	c.Ignore = listFromEnv("IGNORE", JsonList{})
 }
 
+// File extensions grouped by base name, most preferred first
+var DefaultGroupExtensions = JsonList{"jpg", "jpeg", "heic", "heif", "tif",
+	"tiff", "dng", "cr2", "cr3", "nef", "arw", "orf", "rw2", "raf", "xmp"}
+
 type JsonDuration time.Duration
 
 // Parses duration from float64 or string
@@ -99,6 +128,57 @@ func (d *JsonDuration) UnmarshalJSON(b []byte) error {
 	}
 }
 
+type JsonList []string
+
+// Parses a list from a json array or a comma separated string
+func (l *JsonList) UnmarshalJSON(b []byte) error {
+	var v any
+	_ = json.Unmarshal(b, &v)
+	switch value := v.(type) {
+	case string:
+		*l = parseList(value)
+		return nil
+	case []any:
+		list := make(JsonList, 0, len(value))
+		for _, item := range value {
+			str, ok := item.(string)
+			if !ok {
+				return errors.New("invalid list item")
+			}
+			list = append(list, str)
+		}
+		*l = list
+		return nil
+	default:
+		return errors.New("invalid list")
+	}
+}
+
+// Formats the list as a comma separated string (for flag.Value)
+func (l *JsonList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+// Sets the list from a comma separated string (for flag.Value)
+func (l *JsonList) Set(value string) error {
+	*l = parseList(value)
+	return nil
+}
+
+// Splits a comma separated string skipping empty items
+func parseList(value string) JsonList {
+	list := JsonList{}
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 func fromEnv[T comparable](envName string, parseVal func(string) T) T {
@@ -136,6 +216,14 @@ func durationFromEnv(envName string, defaultVal JsonDuration) JsonDuration {
 	})
 }
 
+// Gets a comma separated list from env with fallback to default value
+func listFromEnv(envName string, defaultVal JsonList) JsonList {
+	if env, ok := os.LookupEnv(EnvPrefix + envName); ok {
+		return parseList(env)
+	}
+	return defaultVal
+}
+
 // Gets an integer from env with fallback to default value
 func intFromEnv(envName string, defaultVal int) int {
 	return fromEnv(envName, func(s string) int {
@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"os"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -121,6 +122,50 @@ func TestDurationFromEnv(t *testing.T) {
 	}
 }
 
+func TestJsonList(t *testing.T) {
+	tests := []struct {
+		input string
+		want  JsonList
+		ok    bool
+	}{
+		{`["jpg", "cr2"]`, JsonList{"jpg", "cr2"}, true},
+		{`"jpg, cr2,,xmp "`, JsonList{"jpg", "cr2", "xmp"}, true},
+		{`[]`, JsonList{}, true},
+		{`""`, JsonList{}, true},
+		{`[1, 2]`, nil, false},
+		{`12`, nil, false},
+	}
+	for _, tc := range tests {
+		var list JsonList
+		err := list.UnmarshalJSON([]byte(tc.input))
+		if tc.ok != (err == nil) {
+			t.Errorf("Unexpected error for %v: %v", tc.input, err)
+		}
+		if tc.ok && !reflect.DeepEqual(list, tc.want) {
+			t.Errorf("Expected %v, got %v", tc.want, list)
+		}
+	}
+}
+
+func TestListFromEnv(t *testing.T) {
+	key := "listval"
+	val := JsonList{"a", "b"}
+
+	_ = os.Setenv(EnvPrefix+key, "a,b")
+	if res := listFromEnv(key, nil); !reflect.DeepEqual(res, val) {
+		t.Errorf("Expected %v, got %v", val, res)
+	}
+
+	if res := listFromEnv("non_existing", val); !reflect.DeepEqual(res, val) {
+		t.Errorf("Expected %v, got %v", val, res)
+	}
+
+	_ = os.Setenv(EnvPrefix+key, "")
+	if res := listFromEnv(key, val); len(res) != 0 {
+		t.Errorf("Expected empty list, got %v", res)
+	}
+}
+
 func TestLoadEnv(t *testing.T) {
 	execFolder, _ := os.Getwd()
 	Global.LoadEnv(execFolder)
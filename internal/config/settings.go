@@ -4,7 +4,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -14,12 +17,22 @@ const (
 	QKeyDisplay queryParam = iota
 	QKeyOrder
 	QKeySort
+	QKeyRating
+	QKeyKeyword
+	QKeyPage
+	QKeyLimit
+	QKeyDepth
+	QKeyUp
+	QKeyClass
+	QKeySeed
+	QKeyAlbum
 )
 
 type (
 	QTypeOrder   string
 	QTypeSort    string
 	QTypeDisplay string
+	QTypeClass   string
 )
 
 var queryParams = map[queryParam]string{
@@ -27,6 +40,15 @@ var queryParams = map[queryParam]string{
 	QKeySort:    "s",
 	QKeyOrder:   "o",
 	QKeyDisplay: "y",
+	QKeyRating:  "r",
+	QKeyKeyword: "k",
+	QKeyPage:    "p",
+	QKeyLimit:   "l",
+	QKeyDepth:   "d",
+	QKeyUp:      "u",
+	QKeyClass:   "c",
+	QKeySeed:    "m",
+	QKeyAlbum:   "a",
 }
 
 func (s queryParam) String() string { return queryParams[s] }
@@ -36,13 +58,41 @@ type RequestSettings struct {
 	Sort    QTypeSort    `json:"s"`
 	Order   QTypeOrder   `json:"o"`
 	Display QTypeDisplay `json:"y"`
+	// Filters: minimal rating and keyword (lower case)
+	Rating  int    `json:"r,omitempty"`
+	Keyword string `json:"k,omitempty"`
+	// Letters of the media classes shown, all when empty
+	Classes string `json:"c,omitempty"`
+	// Shuffled order stays the same with the same seed
+	Seed int64 `json:"m,omitempty"`
+	// Pagination: page from 1 and items per page (0 for the global setting)
+	Page  int `json:"p,omitempty"`
+	Limit int `json:"l,omitempty"`
+	// Recursive display: levels of subfolders (0 for all) and, for media,
+	// how many levels up from its folder is the listed one
+	Depth int `json:"d,omitempty"`
+	Up    int `json:"u,omitempty"`
+	// For media, the album it is viewed in; Up is how many levels up from
+	// its folder is the folder of the album
+	Album string `json:"a,omitempty"`
+	// Settings of the folder used when the query does not set them,
+	// the global ones when nil. Queries leave out what is the same.
+	defaults *RequestSettings
 }
 
+// Highest rating of media (stars)
+const MaxRating = 5
+
+// Most levels to go up from the folder of media, more than paths have
+const MaxUp = 1024
+
 const (
 	// NOTE: values below are only defined here and can safely be changed
 	//  	 as long as they remain unique
 	QueryDisplayShow      QTypeDisplay = "w"
 	QueryDisplayFile      QTypeDisplay = "f"
+	QueryDisplayRecursive QTypeDisplay = "r"
+	QueryDisplayTable     QTypeDisplay = "t" // Details of the folder's items
 	QueryDisplayDefault   QTypeDisplay = QueryDisplayShow
 	QueryOrderAsc         QTypeOrder   = "a"
 	QueryOrderDesc        QTypeOrder   = "z"
@@ -50,10 +100,85 @@ const (
 	QueryOrderNameDefault QTypeOrder   = QueryOrderAsc
 	QuerySortName         QTypeSort    = "n"
 	QuerySortDate         QTypeSort    = "d"
+	QuerySortSize         QTypeSort    = "b"
+	QuerySortType         QTypeSort    = "t"
+	QuerySortPixels       QTypeSort    = "x"
+	QuerySortDuration     QTypeSort    = "l"
+	QuerySortShuffle      QTypeSort    = "r"
+	QuerySortCustom       QTypeSort    = "c" // By the sequence in folder settings
 	QuerySortDefault      QTypeSort    = QuerySortDate
 	QueryOrderDefault     QTypeOrder   = QueryOrderDateDefault
 )
 
+// Order used when only the sort is given: the biggest, the longest and
+// the newest come first, names and types are alphabetical
+func DefaultOrder(sort QTypeSort) QTypeOrder {
+	switch sort {
+	case QuerySortDate, QuerySortSize, QuerySortPixels, QuerySortDuration:
+		return QueryOrderDateDefault
+	}
+	return QueryOrderNameDefault
+}
+
+// Media classes in the order of filter toggles
+var QueryClasses = []QTypeClass{
+	QueryClassImage, QueryClassVideo, QueryClassAudio, QueryClassPdf}
+
+const (
+	QueryClassImage QTypeClass = "i"
+	QueryClassVideo QTypeClass = "v"
+	QueryClassAudio QTypeClass = "a"
+	QueryClassPdf   QTypeClass = "p"
+)
+
+// Defaults of the folder the settings are for
+func (cs *RequestSettings) Defaults() RequestSettings {
+	if cs.defaults == nil {
+		return NewRequestSettings()
+	}
+	return *cs.defaults
+}
+
+// Order used when only the sort is given, the folder's order for its sort
+func (cs *RequestSettings) defaultOrderOf(sort QTypeSort) QTypeOrder {
+	if defaults := cs.Defaults(); sort == defaults.Sort {
+		return defaults.Order
+	}
+	return DefaultOrder(sort)
+}
+
+// Keeps known classes in the order of QueryClasses, empty if all are there
+func normalizeClasses(classes string) string {
+	var result string
+	for _, class := range QueryClasses {
+		if strings.Contains(classes, string(class)) {
+			result += string(class)
+		}
+	}
+	if len(result) == len(QueryClasses) {
+		return ""
+	}
+	return result
+}
+
+// Checks if media of a class is shown
+func (cs *RequestSettings) HasClass(class QTypeClass) bool {
+	return cs.Classes == "" || strings.Contains(cs.Classes, string(class))
+}
+
+// Shows or hides a media class. When all are shown only the class remains.
+func (cs RequestSettings) WithClassToggled(class QTypeClass) *RequestSettings {
+	switch {
+	case cs.Classes == "":
+		cs.Classes = string(class)
+	case strings.Contains(cs.Classes, string(class)):
+		cs.Classes = strings.ReplaceAll(cs.Classes, string(class), "")
+	default:
+		cs.Classes = normalizeClasses(cs.Classes + string(class))
+	}
+	return &cs
+}
+
 // Serializes to base64 encoded json
 func (cs *RequestSettings) Marshal() (string, error) {
 	val, _ := json.Marshal(cs)
@@ -70,36 +195,161 @@ func (cs *RequestSettings) Unmarshal(from string) (err error) {
 	return
 }
 
+// Preferences of a user kept between visits: the sort, order and display
+// which differ from the defaults of the folder. The order is kept when it
+// is not the one of the sort.
+func (cs *RequestSettings) Preferences() RequestSettings {
+	var prefs RequestSettings
+	defaults := cs.Defaults()
+	if cs.Sort != defaults.Sort {
+		prefs.Sort = cs.Sort
+	}
+	if cs.Order != cs.defaultOrderOf(cs.Sort) {
+		prefs.Order = cs.Order
+	}
+	if cs.Display != defaults.Display {
+		prefs.Display = cs.Display
+	}
+	return prefs
+}
+
+// Checks if a query sets any of the preferences. Links to files are not
+// a choice of the user.
+func HasPreferences(q url.Values) bool {
+	if q.Get(QKeyDisplay.String()) == string(QueryDisplayFile) {
+		return false
+	}
+	return q.Has(QKeySort.String()) || q.Has(QKeyOrder.String()) ||
+		q.Has(QKeyDisplay.String())
+}
+
+// Applies the known values of preferences, like those read with Unmarshal
+func (cs RequestSettings) WithPreferences(prefs RequestSettings) *RequestSettings {
+	if slices.Contains(slices.Collect(maps.Values(sortNames)), prefs.Sort) {
+		cs.Sort = prefs.Sort
+		cs.Order = cs.defaultOrderOf(cs.Sort)
+	}
+	if prefs.Order == QueryOrderAsc || prefs.Order == QueryOrderDesc {
+		cs.Order = prefs.Order
+	}
+	switch prefs.Display {
+	case QueryDisplayShow, QueryDisplayRecursive, QueryDisplayTable:
+		cs.Display = prefs.Display
+	}
+	return &cs
+}
+
 func (cs RequestSettings) WithOrder(order QTypeOrder) *RequestSettings {
-	return &RequestSettings{Sort: cs.Sort, Order: order, Display: cs.Display}
+	cs.Order = order
+	return &cs
 }
 
 func (cs RequestSettings) WithSort(sort QTypeSort) *RequestSettings {
-	return &RequestSettings{Sort: sort, Order: cs.Order, Display: cs.Display}
+	cs.Sort = sort
+	return &cs
+}
+
+func (cs RequestSettings) WithSeed(seed int64) *RequestSettings {
+	cs.Seed = seed
+	return &cs
+}
+
+func (cs RequestSettings) WithRating(rating int) *RequestSettings {
+	cs.Rating = rating
+	return &cs
+}
+
+func (cs RequestSettings) WithKeyword(keyword string) *RequestSettings {
+	cs.Keyword = strings.ToLower(keyword)
+	return &cs
+}
+
+func (cs RequestSettings) WithDisplay(display QTypeDisplay) *RequestSettings {
+	cs.Display = display
+	return &cs
+}
+
+func (cs RequestSettings) WithUp(up int) *RequestSettings {
+	cs.Up = up
+	return &cs
+}
+
+func (cs RequestSettings) WithAlbum(album string) *RequestSettings {
+	cs.Album = strings.ToLower(album)
+	return &cs
+}
+
+func (cs RequestSettings) WithPage(page int) *RequestSettings {
+	cs.Page = page
+	return &cs
+}
+
+// Items shown on one page, 0 when all are shown
+func (cs *RequestSettings) PageLimit() int {
+	if cs.Limit > 0 {
+		return cs.Limit
+	}
+	return max(0, Global.PageSize)
+}
+
+// Seed of the shuffled order, only when shuffled
+func (cs *RequestSettings) seedParams() (qs []string) {
+	if cs.Sort == QuerySortShuffle && cs.Seed != 0 {
+		qs = append(qs, fmt.Sprintf("%s/%d", QKeySeed, cs.Seed))
+	}
+	return
+}
+
+// Parameters for filters, only those which differ from the defaults.
+// Filters of the folder are cleared with empty values.
+func (cs *RequestSettings) filterParams() (qs []string) {
+	defaults := cs.Defaults()
+	if cs.Rating != defaults.Rating {
+		qs = append(qs, fmt.Sprintf("%s/%d", QKeyRating, cs.Rating))
+	}
+	if cs.Keyword != defaults.Keyword {
+		qs = append(qs, fmt.Sprintf("%s/%s", QKeyKeyword, url.QueryEscape(cs.Keyword)))
+	}
+	if cs.Classes != defaults.Classes {
+		qs = append(qs, fmt.Sprintf("%s/%s", QKeyClass, cs.Classes))
+	}
+	return
 }
 
 // QueryString composes a string with parameters needed for sorting and display
 // settings for use in URIs. It only puts those that are not needed by default.
 func (cs *RequestSettings) QueryString() string {
 	qs := []string{}
-	if cs.Display != QueryDisplayDefault {
+	defaults := cs.Defaults()
+	if cs.Display != defaults.Display {
 		val := fmt.Sprintf("%s/%s", QKeyDisplay, cs.Display)
 		qs = append(qs, val)
 	}
-	var orderString QTypeOrder
-	if cs.Sort == QuerySortDate && cs.Order == QueryOrderAsc {
-		orderString = QueryOrderAsc
+	if (cs.Order == QueryOrderAsc || cs.Order == QueryOrderDesc) &&
+		cs.Order != cs.defaultOrderOf(cs.Sort) {
+		qs = append(qs, fmt.Sprintf("%s/%s", QKeyOrder, cs.Order))
+	}
+	if cs.Sort != defaults.Sort {
+		qs = append(qs, fmt.Sprintf("%s/%s", QKeySort, cs.Sort))
 	}
-	if cs.Sort == QuerySortName && cs.Order == QueryOrderDesc {
-		orderString = QueryOrderDesc
+	qs = append(qs, cs.seedParams()...)
+	qs = append(qs, cs.filterParams()...)
+	if cs.Page > 1 {
+		qs = append(qs, fmt.Sprintf("%s/%d", QKeyPage, cs.Page))
 	}
-	if orderString != "" {
-		qs = append(qs, fmt.Sprintf("%s/%s", QKeyOrder, orderString))
+	if cs.Limit > 0 {
+		qs = append(qs, fmt.Sprintf("%s/%d", QKeyLimit, cs.Limit))
 	}
-	if cs.Sort != QuerySortDefault {
-		qs = append(qs, fmt.Sprintf("%s/%s", QKeySort, cs.Sort))
+	if cs.Depth > 0 {
+		qs = append(qs, fmt.Sprintf("%s/%d", QKeyDepth, cs.Depth))
+	}
+	if cs.Up > 0 {
+		qs = append(qs, fmt.Sprintf("%s/%d", QKeyUp, cs.Up))
 	}
-	result, _ := url.PathUnescape(strings.Join(qs, "/"))
+	if cs.Album != "" {
+		qs = append(qs, fmt.Sprintf("%s/%s", QKeyAlbum, url.QueryEscape(cs.Album)))
+	}
+	result := strings.Join(qs, "/")
 	if result == "" {
 		return result
 	}
@@ -107,12 +357,25 @@ func (cs *RequestSettings) QueryString() string {
 }
 
 // QueryFull returns a string with all display parameters for use in URIs.
+// Filters differing from the defaults, the page limit and depth are added
+// only when set. The page, levels up and the album are left out, as they
+// only apply to the current list.
 // For shortened version see: QueryString()
 func (cs *RequestSettings) QueryFull() string {
-	return fmt.Sprintf("?%s/%s/%s/%s/%s/%s",
+	full := fmt.Sprintf("?%s/%s/%s/%s/%s/%s",
 		QKeyDisplay, cs.Display,
 		QKeyOrder, cs.Order,
 		QKeySort, cs.Sort)
+	for _, param := range append(cs.seedParams(), cs.filterParams()...) {
+		full += "/" + param
+	}
+	if cs.Limit > 0 {
+		full += fmt.Sprintf("/%s/%d", QKeyLimit, cs.Limit)
+	}
+	if cs.Depth > 0 {
+		full += fmt.Sprintf("/%s/%d", QKeyDepth, cs.Depth)
+	}
+	return full
 }
 
 func NewRequestSettings() RequestSettings {
@@ -123,7 +386,20 @@ func NewRequestSettings() RequestSettings {
 }
 
 func RequestSettingsFromQuery(q url.Values) RequestSettings {
-	opts := NewRequestSettings()
+	return RequestSettingsWithDefaults(q, NewRequestSettings())
+}
+
+// Reads the settings from a query falling back to the defaults of a folder
+func RequestSettingsWithDefaults(q url.Values, defaults RequestSettings) RequestSettings {
+	opts := RequestSettings{
+		Sort:     defaults.Sort,
+		Order:    defaults.Order,
+		Display:  defaults.Display,
+		Rating:   defaults.Rating,
+		Keyword:  defaults.Keyword,
+		Classes:  defaults.Classes,
+		defaults: &defaults,
+	}
 	reqOrder := q.Get(QKeyOrder.String())
 	if reqOrder != "" {
 		opts.Order = QTypeOrder(reqOrder)
@@ -131,15 +407,36 @@ func RequestSettingsFromQuery(q url.Values) RequestSettings {
 	if reqSort := q.Get(QKeySort.String()); reqSort != "" {
 		opts.Sort = QTypeSort(reqSort)
 		if reqOrder == "" {
-			if opts.Sort == QuerySortDate {
-				opts.Order = QueryOrderDateDefault
-			} else {
-				opts.Order = QueryOrderNameDefault
-			}
+			opts.Order = opts.defaultOrderOf(opts.Sort)
 		}
 	}
 	if reqDisplay := q.Get(QKeyDisplay.String()); reqDisplay != "" {
 		opts.Display = QTypeDisplay(reqDisplay)
 	}
+	if rating, err := strconv.Atoi(q.Get(QKeyRating.String())); err == nil {
+		opts.Rating = max(0, min(rating, MaxRating))
+	}
+	if q.Has(QKeyKeyword.String()) {
+		opts.Keyword = strings.ToLower(strings.TrimSpace(q.Get(QKeyKeyword.String())))
+	}
+	if q.Has(QKeyClass.String()) {
+		opts.Classes = normalizeClasses(q.Get(QKeyClass.String()))
+	}
+	if seed, err := strconv.ParseInt(q.Get(QKeySeed.String()), 10, 64); err == nil {
+		opts.Seed = seed
+	}
+	if page, err := strconv.Atoi(q.Get(QKeyPage.String())); err == nil && page > 1 {
+		opts.Page = page
+	}
+	if limit, err := strconv.Atoi(q.Get(QKeyLimit.String())); err == nil && limit > 0 {
+		opts.Limit = limit
+	}
+	if depth, err := strconv.Atoi(q.Get(QKeyDepth.String())); err == nil && depth > 0 {
+		opts.Depth = depth
+	}
+	if up, err := strconv.Atoi(q.Get(QKeyUp.String())); err == nil && up > 0 {
+		opts.Up = min(up, MaxUp)
+	}
+	opts.Album = q.Get(QKeyAlbum.String())
 	return opts
 }
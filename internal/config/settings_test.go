@@ -3,6 +3,8 @@ package config
 import (
 	"encoding/base64"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -28,6 +30,11 @@ func TestRequestSettings(t *testing.T) {
 		t.Error("Allowing invalid json")
 	}
 
+	c := a.WithSort(QuerySortName).WithRating(2)
+	if c.Order != a.Order || c.Display != a.Display || c.Rating != 2 {
+		t.Errorf("WithSort and WithRating lose settings: %+v", c)
+	}
+
 	if queryParam(-1).String() != "" {
 		t.Error("WTF queryParam?!")
 	}
@@ -64,6 +71,49 @@ func TestQueryString(t *testing.T) {
 			QKeyOrder.String(): []string{"invalid"},
 			"nonexisting":      []string{"invalid"},
 		}, ""},
+		{url.Values{
+			QKeyRating.String():  []string{"3"},
+			QKeyKeyword.String(): []string{"Holiday fun/2024"},
+		}, "?r/3/k/holiday+fun%2F2024"},
+		{url.Values{
+			QKeyRating.String(): []string{"9"},
+		}, "?r/5"},
+		{url.Values{
+			QKeyRating.String(): []string{"none"},
+		}, ""},
+		{url.Values{
+			QKeySort.String():  []string{string(QuerySortName)},
+			QKeyPage.String():  []string{"3"},
+			QKeyLimit.String(): []string{"50"},
+		}, "?s/n/p/3/l/50"},
+		{url.Values{
+			QKeyPage.String():  []string{"1"},
+			QKeyLimit.String(): []string{"-5"},
+		}, ""},
+		{url.Values{
+			QKeyDisplay.String(): []string{string(QueryDisplayRecursive)},
+			QKeyDepth.String():   []string{"2"},
+			QKeyUp.String():      []string{"1"},
+		}, "?y/r/d/2/u/1"},
+		{url.Values{
+			QKeyUp.String():    []string{"2"},
+			QKeyAlbum.String(): []string{"best of 2024"},
+		}, "?u/2/a/best+of+2024"},
+		{url.Values{
+			QKeySort.String(): []string{string(QuerySortSize)},
+		}, "?s/b"},
+		{url.Values{
+			QKeySort.String():  []string{string(QuerySortType)},
+			QKeyOrder.String(): []string{string(QueryOrderDesc)},
+		}, "?o/z/s/t"},
+		{url.Values{
+			QKeySort.String(): []string{string(QuerySortShuffle)},
+			QKeySeed.String(): []string{"42"},
+		}, "?s/r/m/42"},
+		{url.Values{
+			QKeySort.String(): []string{string(QuerySortName)},
+			QKeySeed.String(): []string{"42"},
+		}, "?s/n"},
 	}
 
 	for _, tc := range tests {
@@ -100,6 +150,24 @@ func TestQueryFull(t *testing.T) {
 			QKeyOrder.String(): []string{string(QueryOrderDesc)},
 			QKeySort.String():  []string{string(QuerySortName)},
 		}, "?y/w/o/z/s/n"},
+		{url.Values{
+			QKeyRating.String():  []string{"2"},
+			QKeyKeyword.String(): []string{"sea"},
+		}, "?y/w/o/z/s/d/r/2/k/sea"},
+		{url.Values{
+			QKeyPage.String():  []string{"4"},
+			QKeyLimit.String(): []string{"10"},
+		}, "?y/w/o/z/s/d/l/10"},
+		{url.Values{
+			QKeyDisplay.String(): []string{string(QueryDisplayRecursive)},
+			QKeyDepth.String():   []string{"3"},
+			QKeyUp.String():      []string{"2"},
+		}, "?y/r/o/z/s/d/d/3"},
+		{url.Values{
+			QKeySort.String():  []string{string(QuerySortShuffle)},
+			QKeySeed.String():  []string{"7"},
+			QKeyClass.String(): []string{"i"},
+		}, "?y/w/o/a/s/r/m/7/c/i"},
 	}
 
 	for _, tc := range tests {
@@ -109,3 +177,124 @@ func TestQueryFull(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryWithDefaults(t *testing.T) {
+	defaults := RequestSettings{Sort: QuerySortDate, Order: QueryOrderAsc,
+		Display: QueryDisplayShow, Rating: 3, Classes: "i"}
+	tests := []struct {
+		input url.Values
+		want  string
+	}{
+		{url.Values{}, ""},
+		{url.Values{QKeySort.String(): []string{string(QuerySortDate)}}, ""},
+		{url.Values{QKeyOrder.String(): []string{string(QueryOrderDesc)}}, "?o/z"},
+		{url.Values{QKeySort.String(): []string{string(QuerySortName)}}, "?s/n"},
+		{url.Values{QKeyRating.String(): []string{"0"}}, "?r/0"},
+		{url.Values{QKeyClass.String(): []string{""}}, "?c/"},
+		{url.Values{QKeyKeyword.String(): []string{"sea"}}, "?k/sea"},
+	}
+	for _, tc := range tests {
+		query := RequestSettingsWithDefaults(tc.input, defaults)
+		result := query.QueryString()
+		if result != tc.want {
+			t.Errorf("got %v, want %v", result, tc.want)
+		}
+		// Parsing the query again gives the same settings
+		q := url.Values{}
+		if result != "" {
+			parts := strings.Split(result[1:], "/")
+			for i := 0; i+1 < len(parts); i += 2 {
+				q.Set(parts[i], parts[i+1])
+			}
+		}
+		if again := RequestSettingsWithDefaults(q, defaults); !reflect.DeepEqual(again, query) {
+			t.Errorf("%v parsed again as %+v, want %+v", result, again, query)
+		}
+	}
+	up := url.Values{QKeyUp.String(): []string{"9223372036854775807"}}
+	if query := RequestSettingsWithDefaults(up, defaults); query.Up != MaxUp {
+		t.Errorf("levels up = %v, want %v", query.Up, MaxUp)
+	}
+}
+
+func TestClasses(t *testing.T) {
+	tests := []struct {
+		classes string
+		toggle  QTypeClass
+		want    string
+	}{
+		{"", QueryClassVideo, "v"},
+		{"v", QueryClassVideo, ""},
+		{"v", QueryClassImage, "iv"},
+		{"iva", QueryClassPdf, ""},
+		{"ivp", QueryClassVideo, "ip"},
+	}
+	for _, tc := range tests {
+		opts := NewRequestSettings()
+		opts.Classes = tc.classes
+		if result := opts.WithClassToggled(tc.toggle).Classes; result != tc.want {
+			t.Errorf("%q.WithClassToggled(%q) = %q, want %q",
+				tc.classes, tc.toggle, result, tc.want)
+		}
+	}
+	for query, want := range map[string]string{
+		"pi": "ip", "xyz": "", "aivp": "", "vv": "v"} {
+		opts := RequestSettingsFromQuery(url.Values{QKeyClass.String(): []string{query}})
+		if opts.Classes != want {
+			t.Errorf("classes from %q = %q, want %q", query, opts.Classes, want)
+		}
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	chosen := RequestSettings{Sort: QuerySortName, Order: QueryOrderDesc,
+		Display: QueryDisplayRecursive, Rating: 3, Page: 2}
+	prefs := chosen.Preferences()
+	saved, _ := prefs.Marshal()
+	var read RequestSettings
+	if err := read.Unmarshal(saved); err != nil {
+		t.Fatal(err)
+	}
+	defaults := NewRequestSettings()
+	want := RequestSettings{Sort: QuerySortName, Order: QueryOrderDesc,
+		Display: QueryDisplayRecursive}
+	if result := *defaults.WithPreferences(read); result != want {
+		t.Errorf("WithPreferences(%+v) = %+v, want %+v", read, result, want)
+	}
+
+	tests := []struct {
+		prefs RequestSettings
+		want  RequestSettings
+	}{
+		{RequestSettings{Sort: "bla", Order: "up", Display: "foo"}, defaults},
+		{RequestSettings{Sort: QuerySortName}, RequestSettings{
+			Sort: QuerySortName, Order: QueryOrderAsc, Display: defaults.Display}},
+		{RequestSettings{Display: QueryDisplayRecursive}, RequestSettings{
+			Sort: defaults.Sort, Order: defaults.Order, Display: QueryDisplayRecursive}},
+		{RequestSettings{Display: QueryDisplayTable}, RequestSettings{
+			Sort: defaults.Sort, Order: defaults.Order, Display: QueryDisplayTable}},
+		{RequestSettings{Display: QueryDisplayFile}, defaults},
+	}
+	for _, tc := range tests {
+		if result := *defaults.WithPreferences(tc.prefs); result != tc.want {
+			t.Errorf("WithPreferences(%+v) = %+v, want %+v", tc.prefs, result, tc.want)
+		}
+	}
+
+	folder := RequestSettingsWithDefaults(url.Values{QKeyDisplay.String(): {"t"}},
+		FolderSettings{Sort: "name"}.RequestDefaults())
+	want = RequestSettings{Display: QueryDisplayTable}
+	if result := folder.Preferences(); result != want {
+		t.Errorf("Preferences() in a folder = %+v, want %+v", result, want)
+	}
+
+	if HasPreferences(url.Values{QKeyRating.String(): {"2"}}) {
+		t.Error("HasPreferences() of a filter")
+	}
+	if !HasPreferences(url.Values{QKeyOrder.String(): {"a"}}) {
+		t.Error("HasPreferences() of an order")
+	}
+	if HasPreferences(url.Values{QKeyDisplay.String(): {string(QueryDisplayFile)}}) {
+		t.Error("HasPreferences() of a link to a file")
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parses the ids of revoked share links, one per line. Words after the id
+// are left for notes, like who the link was for.
+func parseRevoked(data []byte) (map[string]bool, error) {
+	revoked := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		revoked[strings.ToLower(strings.Fields(text)[0])] = true
+	}
+	return revoked, scanner.Err()
+}
+
+// Ids of the share links which no longer open
+var revokedCache cachedFile[map[string]bool]
+
+// Revoked reads the file of revoked share links, cached until it changes
+func Revoked() (map[string]bool, error) {
+	if Global.RevokedShares == "" {
+		return nil, nil
+	}
+	return revokedCache.read(Global.RevokedShares, parseRevoked)
+}
+
+// Length of share link ids in hex digits
+const shareIdLength = 16
+
+// ShareToken is the key of a share link to a folder or file and what is
+// below it, until it expires: the path in hex, the expiry and their
+// signature. It is made of hex digits and dots since queries are lowercase.
+func ShareToken(scope string, expires time.Time) string {
+	name := hex.EncodeToString([]byte(path.Clean("/" + scope)))
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return name + "." + expiry + "." + Sign("share", name, expiry)
+}
+
+// ShareId names a share link in the file of revoked links
+func ShareId(token string) string {
+	signature := token[strings.LastIndex(token, ".")+1:]
+	return signature[:min(shareIdLength, len(signature))]
+}
+
+// SharedScope finds the path of a share link which is signed, not expired
+// and not revoked, with its expiry
+func SharedScope(token string) (string, time.Time, bool) {
+	name, rest, _ := strings.Cut(token, ".")
+	expiry, signature, _ := strings.Cut(rest, ".")
+	if !ValidSignature(signature, "share", name, expiry) {
+		return "", time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return "", time.Time{}, false
+	}
+	revoked, err := Revoked()
+	if err != nil || revoked[ShareId(token)] {
+		return "", time.Time{}, false // Unreadable files revoke all links
+	}
+	scope, err := hex.DecodeString(name)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return string(scope), time.Unix(unix, 0), true
+}
+
+// InScope tells if a path is a shared path or below it
+func InScope(scope, relPath string) bool {
+	relPath = path.Clean("/" + strings.TrimPrefix(relPath, "/"))
+	return relPath == scope || strings.HasPrefix(relPath, strings.TrimSuffix(scope, "/")+"/")
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+func TestSharedScope(t *testing.T) {
+	saved := Global
+	defer func() { Global = saved }()
+	Global.Secret = "test"
+	Global.RevokedShares = filepath.Join(t.TempDir(), "revoked")
+
+	week := time.Now().Add(7 * 24 * time.Hour)
+	valid := ShareToken("trip/day 1", week)
+	revoked := ShareToken("trip", week)
+	data := "# revoked links\n" + ShareId(revoked) + " for the neighbours\n"
+	if err := os.WriteFile(Global.RevokedShares, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+		scope string
+		ok    bool
+	}{
+		{"valid", valid, "/trip/day 1", true},
+		{"expired", ShareToken("trip", time.Now().Add(-time.Minute)), "", false},
+		{"revoked", revoked, "", false},
+		{"other path", "2f" + valid[len("2f747269702f6461792031"):], "", false},
+		{"no signature", valid[:len(valid)-64], "", false},
+		{"empty", "", "", false},
+	}
+	for _, tc := range tests {
+		scope, expires, ok := SharedScope(tc.token)
+		if scope != tc.scope || ok != tc.ok {
+			t.Errorf("%s: SharedScope() = %q, %v, want %q, %v", tc.name, scope, ok, tc.scope, tc.ok)
+		}
+		if ok && expires.Unix() != week.Unix() {
+			t.Errorf("%s: SharedScope() expires %v, want %v", tc.name, expires, week)
+		}
+	}
+
+	Global.Secret = "other"
+	if _, _, ok := SharedScope(valid); ok {
+		t.Error("SharedScope() opens links of another secret")
+	}
+}
+
+func TestFolderAccessShared(t *testing.T) {
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = afero.NewMemMapFs()
+	files := map[string]string{
+		"/home/_foldergal.yaml":         "access: [ann]",
+		"/home/trip/_foldergal.yaml":    "password: '$2y$05$abc'",
+		"/home/trip/me/_foldergal.yaml": "access: [ann]",
+		"/home/trip/pw/_foldergal.yaml": "password: '$2y$05$def'",
+	}
+	for name, data := range files {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		folder  string
+		allowed bool
+		locked  string
+	}{
+		{"/home", false, ""},
+		{"/home/trip", true, ""},
+		{"/home/trip/day1", true, ""},
+		{"/home/trip/me", false, ""},              // Its own rules still apply
+		{"/home/trip/pw", false, "/home/trip/pw"}, // And its own password
+		{"/home/other", false, ""},
+	}
+	for _, tc := range tests {
+		access := NewFolderAccess("").WithShared("home/trip")
+		if result := access.Allows(tc.folder); result != tc.allowed {
+			t.Errorf("Allows(%q) = %v, want %v", tc.folder, result, tc.allowed)
+		}
+		if result := access.LockedFolder(tc.folder); result != tc.locked {
+			t.Errorf("LockedFolder(%q) = %q, want %q", tc.folder, result, tc.locked)
+		}
+	}
+
+	access := NewFolderAccess("").WithShared("/a/photo.jpg").OnlyShared()
+	if !access.AllowsPath("a/photo.jpg", false) || access.AllowsPath("a/other.jpg", false) ||
+		access.Allows("/") {
+		t.Error("FolderAccess opens more than a shared file")
+	}
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// File parsed again only when it changes, like the htpasswd file
+type cachedFile[T any] struct {
+	mu      sync.Mutex
+	file    string
+	modTime time.Time
+	size    int64
+	value   T
+	err     error
+}
+
+// Reads a file with parse, or returns what it parsed before when the file
+// has not changed
+func (c *cachedFile[T]) read(file string, parse func([]byte) (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var empty T
+	stat, err := os.Stat(file)
+	if err != nil {
+		return empty, err
+	}
+	if c.file == file && c.modTime.Equal(stat.ModTime()) && c.size == stat.Size() {
+		return c.value, c.err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return empty, err
+	}
+	c.file, c.modTime, c.size = file, stat.ModTime(), stat.Size()
+	c.value, c.err = parse(data)
+	return c.value, c.err
+}
+
+// Users of the htpasswd file by their names, with their bcrypt hashes
+var usersCache cachedFile[map[string][]byte]
+
+// Signature of a bcrypt hash, like $2y$10$... from htpasswd -B
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// Parses the lines user:hash of an htpasswd file. Lines with other hashes
+// than bcrypt are left out and reported.
+func parseHtpasswd(data []byte) (map[string][]byte, error) {
+	hashes := make(map[string][]byte)
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			errs = append(errs, fmt.Errorf("line %d: invalid user", line))
+			continue
+		}
+		if !slices.ContainsFunc(bcryptPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(hash, prefix)
+		}) {
+			errs = append(errs, fmt.Errorf("line %d: %s: only bcrypt passwords are supported", line, user))
+			continue
+		}
+		hashes[user] = []byte(hash)
+	}
+	return hashes, errors.Join(errs...)
+}
+
+// AuthEnabled tells if visitors have to log in with the users of the
+// htpasswd file
+func AuthEnabled() bool {
+	return Global.Htpasswd != ""
+}
+
+// Users reads the htpasswd file, cached until it changes
+func Users() (map[string][]byte, error) {
+	if !AuthEnabled() {
+		return nil, nil
+	}
+	return usersCache.read(Global.Htpasswd, parseHtpasswd)
+}
+
+// HasUser checks if a user is still in the htpasswd file
+func HasUser(user string) bool {
+	hashes, _ := Users()
+	_, ok := hashes[user]
+	return ok
+}
+
+// CheckPassword compares a password with the hash of the user
+func CheckPassword(user, password string) bool {
+	hashes, _ := Users()
+	hash, ok := hashes[user]
+	if !ok {
+		// Takes as long as for a user, not telling which users exist
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("foldergal"), bcrypt.DefaultCost)
+	return hash
+})
+
+// NewSecret makes a random key to sign cookies and links with
+func NewSecret() string {
+	return rand.Text() + rand.Text()
+}
+
+// Sign returns a hex MAC of the parts with Configuration.Secret
+func Sign(parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(Global.Secret))
+	for _, part := range parts {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidSignature checks a MAC made by Sign in constant time
+func ValidSignature(signature string, parts ...string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(parts...)))
+}
+
+// Length of user tokens in hex digits
+const tokenLength = 32
+
+// UserToken is the key of a user to feeds and thumbnails without logging
+// in, for feed readers and chat previews. It changes with the secret.
+func UserToken(user string) string {
+	return Sign("token", user)[:tokenLength]
+}
+
+// TokenUser finds the user of a token from UserToken
+func TokenUser(token string) (string, bool) {
+	if len(token) != tokenLength {
+		return "", false
+	}
+	hashes, _ := Users()
+	for _, user := range slices.Sorted(maps.Keys(hashes)) {
+		if hmac.Equal([]byte(token), []byte(UserToken(user))) {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// TokenQuery is the query of a route, like thumb, with the token of the
+// user when feeds and thumbnails open with tokens
+func TokenQuery(user, route string) string {
+	if !AuthEnabled() || !Global.FeedTokens || user == "" {
+		return route
+	}
+	return "token/" + UserToken(user) + "/" + route
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Writes an htpasswd file with the users and their passwords
+func writeHtpasswd(t *testing.T, passwords map[string]string) string {
+	t.Helper()
+	var data []byte
+	for _, user := range slices.Sorted(maps.Keys(passwords)) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(passwords[user]), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, user+":"+string(hash)+"\n"...)
+	}
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	data := "# users\nann:$2y$05$abc\n\nbob:$apr1$xyz$abc\nnocolon\n:$2a$05$def\ncid:$2b$05$ghi\n"
+	hashes, err := parseHtpasswd([]byte(data))
+	want := map[string][]byte{"ann": []byte("$2y$05$abc"), "cid": []byte("$2b$05$ghi")}
+	if !reflect.DeepEqual(hashes, want) {
+		t.Errorf("parseHtpasswd() = %q, want %q", hashes, want)
+	}
+	if err == nil {
+		t.Error("parseHtpasswd() has no error for unsupported lines")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	saved := Global
+	defer func() { Global = saved }()
+	Global.Htpasswd = writeHtpasswd(t, map[string]string{"ann": "first", "bob": "second"})
+	Global.Secret = "test"
+
+	tests := []struct {
+		user, password string
+		want           bool
+	}{
+		{"ann", "first", true},
+		{"bob", "second", true},
+		{"ann", "second", false},
+		{"eve", "first", false},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		if result := CheckPassword(tc.user, tc.password); result != tc.want {
+			t.Errorf("CheckPassword(%q, %q) = %v, want %v", tc.user, tc.password, result, tc.want)
+		}
+	}
+	if !HasUser("ann") || HasUser("eve") {
+		t.Error("HasUser() does not follow the file")
+	}
+
+	token := UserToken("bob")
+	if user, ok := TokenUser(token); !ok || user != "bob" {
+		t.Errorf("TokenUser(%q) = %q, %v, want bob", token, user, ok)
+	}
+	if _, ok := TokenUser(UserToken("eve")); ok {
+		t.Error("TokenUser() accepts the token of an unknown user")
+	}
+	Global.Secret = "other"
+	if _, ok := TokenUser(token); ok {
+		t.Error("TokenUser() accepts a token of another secret")
+	}
+}
+
+func TestSign(t *testing.T) {
+	saved := Global.Secret
+	defer func() { Global.Secret = saved }()
+	Global.Secret = "test"
+	signature := Sign("session", "ann")
+	if !ValidSignature(signature, "session", "ann") {
+		t.Error("ValidSignature() rejects its signature")
+	}
+	for _, parts := range [][]string{{"session", "bob"}, {"sessionann"}, {"session", "ann", ""}} {
+		if ValidSignature(signature, parts...) {
+			t.Errorf("ValidSignature() accepts other parts %q", parts)
+		}
+	}
+}
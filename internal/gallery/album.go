@@ -0,0 +1,50 @@
+package gallery
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fvbommel/sortorder"
+)
+
+// AlbumFiles finds the indexed media of an album in its order. Items are
+// paths or glob patterns relative to the folder of the album, where **
+// matches any folders; a path of a folder adds all its media. Media
+// matched by an item are sorted by path and each is added once.
+func AlbumFiles(folder string, items []string) []IndexEntry {
+	folder = strings.Trim(folder, "/")
+	entries := IndexFiles(folder)
+	sort.Slice(entries, func(i, j int) bool {
+		return sortorder.NaturalLess(entries[i].Path, entries[j].Path)
+	})
+	relPath := func(entry IndexEntry) string {
+		if folder == "" {
+			return entry.Path
+		}
+		return strings.TrimPrefix(entry.Path, folder+"/")
+	}
+	added := make(map[string]bool)
+	var result []IndexEntry
+	for _, item := range items {
+		// Items cannot reach out of the folder of the album
+		pattern := strings.Trim(path.Clean("/"+strings.TrimSpace(item)), "/")
+		if pattern == "" {
+			continue
+		}
+		segments := strings.Split(pattern, "/")
+		isGlob := strings.ContainsAny(pattern, "*?[")
+		for _, entry := range entries {
+			rel := relPath(entry)
+			if added[entry.Path] {
+				continue
+			}
+			if matchSegments(segments, strings.Split(rel, "/")) ||
+				!isGlob && strings.HasPrefix(rel, pattern+"/") {
+				added[entry.Path] = true
+				result = append(result, entry)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package gallery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAlbumFiles(t *testing.T) {
+	saved := index
+	defer func() { index = saved }()
+	index = make(map[string]IndexEntry)
+	for _, p := range []string{"trip/day1/a.jpg", "trip/day1/b.jpg",
+		"trip/day2/c.jpg", "trip/day2/d.png", "trip/day10/e.jpg", "home/f.jpg"} {
+		index[p] = IndexEntry{Path: p}
+	}
+	index["trip/day2"] = IndexEntry{Path: "trip/day2", IsDir: true}
+
+	tests := []struct {
+		folder string
+		items  []string
+		want   []string
+	}{
+		{"", []string{"home/f.jpg", "trip/day1/a.jpg"},
+			[]string{"home/f.jpg", "trip/day1/a.jpg"}},
+		{"", []string{"trip/day2", "trip/*/a.jpg"},
+			[]string{"trip/day2/c.jpg", "trip/day2/d.png", "trip/day1/a.jpg"}},
+		{"", []string{"**/*.jpg"}, []string{"home/f.jpg", "trip/day1/a.jpg",
+			"trip/day1/b.jpg", "trip/day2/c.jpg", "trip/day10/e.jpg"}},
+		{"trip", []string{"day1/b.jpg", "*/*.jpg"}, []string{"trip/day1/b.jpg",
+			"trip/day1/a.jpg", "trip/day2/c.jpg", "trip/day10/e.jpg"}},
+		{"trip", []string{"../home/f.jpg", "missing.jpg"}, nil},
+	}
+	for _, tc := range tests {
+		var result []string
+		for _, entry := range AlbumFiles(tc.folder, tc.items) {
+			result = append(result, entry.Path)
+		}
+		if !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("AlbumFiles(%q, %v) = %v, want %v", tc.folder, tc.items, result, tc.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package gallery
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// Drops the cached details of a removed or renamed file, or of every file
+// in a removed folder, so that caches do not keep growing with files which
+// are gone. Paths are relative to the root folder.
+func forgetCached(relPath string) {
+	forget(metadataCache, &metadataCacheMu, relPath)
+	forget(captionCache, &captionCacheMu, relPath)
+	forget(infoCache, &infoCacheMu, relPath)
+	forget(motionCache, &motionCacheMu, relPath)
+}
+
+func forget[V any](cache map[string]V, mu *sync.RWMutex, relPath string) {
+	relPath = path.Clean("/" + relPath)
+	mu.Lock()
+	defer mu.Unlock()
+	for key := range cache {
+		if clean := path.Clean("/" + key); clean == relPath ||
+			strings.HasPrefix(clean, relPath+"/") {
+			delete(cache, key)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package gallery
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestForgetCached(t *testing.T) {
+	infoCache = map[string]cachedInfo{
+		"/trip/beach.jpg":    {},
+		"/trip/day2/sea.mp4": {},
+		"/trip2/cat.jpg":     {},
+		"/home/dog.jpg":      {},
+	}
+	forgetCached("trip/beach.jpg")
+	forgetCached("trip/day2")
+	want := []string{"/home/dog.jpg", "/trip2/cat.jpg"}
+	if keys := slices.Sorted(maps.Keys(infoCache)); !slices.Equal(keys, want) {
+		t.Errorf("forgetCached left %v, want %v", keys, want)
+	}
+	forgetCached("trip2")
+	forgetCached("home")
+	if len(infoCache) != 0 {
+		t.Errorf("forgetCached left %v, want none", infoCache)
+	}
+}
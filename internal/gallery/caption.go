@@ -0,0 +1,89 @@
+package gallery
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+// Extensions of caption files named after media, like photo.jpg.txt
+var captionExtensions = []string{".txt", ".md"}
+
+// Captions longer than this are cut
+var maxCaptionSize int64 = 16 * 1024
+
+type cachedCaption struct {
+	modTime time.Time
+	caption string
+}
+
+var (
+	captionCache   = make(map[string]cachedCaption)
+	captionCacheMu sync.RWMutex
+)
+
+// Maps media files to their caption files among the names in a folder
+func FindCaptionFiles(names []string) map[string]string {
+	texts := make(map[string]string)
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		for _, ext := range captionExtensions {
+			if strings.HasSuffix(lower, ext) {
+				texts[strings.TrimSuffix(lower, ext)] = name
+			}
+		}
+	}
+	captions := make(map[string]string)
+	if len(texts) == 0 {
+		return captions
+	}
+	for _, name := range names {
+		if caption, ok := texts[strings.ToLower(name)]; ok && IsValidMedia(name) {
+			captions[name] = caption
+		}
+	}
+	return captions
+}
+
+// Finds the caption file of a media file by checking its possible names
+func FindCaptionFile(fullPath string) string {
+	for _, ext := range captionExtensions {
+		if info, err := storage.Root.Stat(fullPath + ext); err == nil && !info.IsDir() {
+			return fullPath + ext
+		}
+	}
+	return ""
+}
+
+// ReadCaption gets the text of a caption file.
+// Results are cached until the file changes.
+func ReadCaption(captionPath string) string {
+	info, err := storage.Root.Stat(captionPath)
+	if err != nil {
+		return ""
+	}
+	captionCacheMu.RLock()
+	cached, ok := captionCache[captionPath]
+	captionCacheMu.RUnlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.caption
+	}
+
+	file, err := storage.Root.Open(captionPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	text, err := io.ReadAll(io.LimitReader(file, maxCaptionSize))
+	if err != nil {
+		return ""
+	}
+	caption := strings.TrimSpace(string(text))
+	captionCacheMu.Lock()
+	captionCache[captionPath] = cachedCaption{modTime: info.ModTime(), caption: caption}
+	captionCacheMu.Unlock()
+	return caption
+}
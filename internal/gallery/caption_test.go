@@ -0,0 +1,24 @@
+package gallery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCaptionFiles(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  map[string]string
+	}{
+		{[]string{"a.jpg", "a.jpg.txt", "b.png", "b.png.MD", "c.mp4"},
+			map[string]string{"a.jpg": "a.jpg.txt", "b.png": "b.png.MD"}},
+		{[]string{"notes.txt", "a.jpg", "a.txt"}, map[string]string{}},
+		{[]string{"a.jpg.txt"}, map[string]string{}},
+		{nil, map[string]string{}},
+	}
+	for _, tc := range tests {
+		if result := FindCaptionFiles(tc.names); !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("FindCaptionFiles(%v) = %v, want %v", tc.names, result, tc.want)
+		}
+	}
+}
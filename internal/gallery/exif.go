@@ -0,0 +1,129 @@
+package gallery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+// EXIF tags with dates, the original capture is preferred
+const (
+	exifTagDateTime          = 0x0132
+	exifTagExifIfd           = 0x8769
+	exifTagDateTimeOriginal  = 0x9003
+	exifTagDateTimeDigitized = 0x9004
+)
+
+var (
+	jpegExifHeader = []byte("Exif\x00\x00")
+	tiffHeaderLE   = []byte("II*\x00")
+	tiffHeaderBE   = []byte("MM\x00*")
+	exifDateFormat = "2006:01:02 15:04:05"
+)
+
+// How much from the start of TIFF based files (like RAW) is read for EXIF
+var exifHeadSize = 256 * 1024
+
+// Reads the time a photo was taken from its EXIF data, zero when unknown
+func readCaptureTime(fullPath string) time.Time {
+	if GetMediaClass(fullPath) != MediaImage {
+		return time.Time{}
+	}
+	file, err := storage.Root.Open(fullPath)
+	if err != nil {
+		return time.Time{}
+	}
+	defer file.Close()
+	capture, _ := readExifTime(file)
+	return capture
+}
+
+// Finds the EXIF data in a JPEG or a TIFF based file and reads its date
+func readExifTime(file io.ReadSeeker) (time.Time, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return time.Time{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return time.Time{}, err
+	}
+	var tiff []byte
+	var err error
+	if bytes.Equal(head, tiffHeaderLE) || bytes.Equal(head, tiffHeaderBE) {
+		tiff, err = io.ReadAll(io.LimitReader(file, int64(exifHeadSize)))
+	} else {
+		tiff, err = jpegSegment(file, 0xe1, jpegExifHeader)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return exifTime(tiff)
+}
+
+// Reads the capture date from TIFF structured EXIF data
+func exifTime(tiff []byte) (time.Time, error) {
+	if len(tiff) < 8 {
+		return time.Time{}, errors.New("truncated exif header")
+	}
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, tiffHeaderLE):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, tiffHeaderBE):
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errors.New("invalid exif header")
+	}
+	ifd0 := readIfd(tiff, order, order.Uint32(tiff[4:]))
+	dates := []string{ifd0[exifTagDateTime]}
+	if offset, ok := ifd0[exifTagExifIfd]; ok && len(offset) == 4 {
+		exifIfd := readIfd(tiff, order, order.Uint32([]byte(offset)))
+		dates = []string{exifIfd[exifTagDateTimeOriginal],
+			exifIfd[exifTagDateTimeDigitized], ifd0[exifTagDateTime]}
+	}
+	location := config.Global.TimeLocation
+	if location == nil {
+		location = time.Local
+	}
+	for _, date := range dates {
+		date = strings.TrimRight(date, "\x00 ")
+		if t, err := time.ParseInLocation(exifDateFormat, date, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("exif date not found")
+}
+
+// Reads the date (ASCII) and IFD pointer (LONG) values of an IFD by tag.
+// Pointers are kept as their raw 4 bytes. Offsets outside of the data,
+// as in truncated or damaged files, are skipped.
+func readIfd(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]string {
+	values := make(map[uint16]string)
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return values
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := uint64(offset) + 2 + uint64(i)*12
+		if entry+12 > uint64(len(tiff)) {
+			break
+		}
+		tag := order.Uint16(tiff[entry:])
+		switch kind, size := order.Uint16(tiff[entry+2:]), order.Uint32(tiff[entry+4:]); {
+		case tag == exifTagExifIfd && kind == 4: // LONG
+			values[tag] = string(tiff[entry+8 : entry+12])
+		case kind == 2 && size > 4: // ASCII stored at an offset
+			start := uint64(order.Uint32(tiff[entry+8:]))
+			if start+uint64(size) <= uint64(len(tiff)) {
+				values[tag] = string(tiff[start : start+uint64(size)])
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,94 @@
+package gallery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// Builds little endian TIFF data with the dates in IFD0 and the Exif IFD
+func testTiff(dateTime, original string) []byte {
+	order := binary.LittleEndian
+	data := append([]byte{}, tiffHeaderLE...)
+	data = order.AppendUint32(data, 8)
+	// IFD0 at 8 with 2 entries, Exif IFD at 38 with 1 entry, strings at 56
+	data = order.AppendUint16(data, 2)
+	data = order.AppendUint16(data, exifTagDateTime)
+	data = order.AppendUint16(data, 2)
+	data = order.AppendUint32(data, uint32(len(dateTime)))
+	data = order.AppendUint32(data, 56)
+	data = order.AppendUint16(data, exifTagExifIfd)
+	data = order.AppendUint16(data, 4)
+	data = order.AppendUint32(data, 1)
+	data = order.AppendUint32(data, 38)
+	data = order.AppendUint32(data, 0)
+	data = order.AppendUint16(data, 1)
+	data = order.AppendUint16(data, exifTagDateTimeOriginal)
+	data = order.AppendUint16(data, 2)
+	data = order.AppendUint32(data, uint32(len(original)))
+	data = order.AppendUint32(data, uint32(56+len(dateTime)))
+	data = order.AppendUint32(data, 0)
+	return append(append(data, dateTime...), original...)
+}
+
+func TestReadExifTime(t *testing.T) {
+	jpeg := func(tiff []byte) []byte {
+		segment := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+		binary.BigEndian.PutUint16(segment[4:], uint16(len(tiff)+len(jpegExifHeader)+2))
+		segment = append(append(segment, jpegExifHeader...), tiff...)
+		return append(segment, 0xff, 0xda)
+	}
+	want := time.Date(2024, 5, 3, 10, 20, 30, 0, time.Local)
+	tests := []struct {
+		name string
+		data []byte
+		want time.Time
+	}{
+		{"jpeg original", jpeg(testTiff("2001:01:01 00:00:00\x00",
+			"2024:05:03 10:20:30\x00")), want},
+		{"tiff original", testTiff("2001:01:01 00:00:00\x00",
+			"2024:05:03 10:20:30\x00"), want},
+		{"fallback", jpeg(testTiff("2024:05:03 10:20:30\x00",
+			"    :  :     :  :  \x00")), want},
+		{"no dates", jpeg(testTiff("unknown", "unknown")), time.Time{}},
+		{"no exif", []byte{0xff, 0xd8, 0xff, 0xda}, time.Time{}},
+	}
+	for _, tc := range tests {
+		result, _ := readExifTime(bytes.NewReader(tc.data))
+		if !result.Equal(tc.want) {
+			t.Errorf("%s: readExifTime() = %v, want %v", tc.name, result, tc.want)
+		}
+	}
+}
+
+func TestExifTimeDamaged(t *testing.T) {
+	valid := testTiff("2024:05:03 10:20:30\x00", "2024:05:03 10:20:30\x00")
+	order := binary.LittleEndian
+	tests := map[string][]byte{
+		"empty":         {},
+		"header only":   []byte("II*\x00"),
+		"short offset":  []byte("II*\x00\x08\x00"),
+		"big endian":    []byte("MM\x00*\x00"),
+		"garbage":       []byte("not exif data at all"),
+		"offset beyond": order.AppendUint32([]byte("II*\x00"), 0xffffffff),
+		"ifd beyond":    order.AppendUint32([]byte("II*\x00"), 7),
+		"truncated ifd": valid[:20],
+		"no strings":    valid[:56],
+	}
+	for name, tiff := range tests {
+		if result, err := exifTime(tiff); err == nil {
+			t.Errorf("%s: exifTime() = %v, want an error", name, result)
+		}
+		jpeg := append([]byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}, jpegExifHeader...)
+		binary.BigEndian.PutUint16(jpeg[4:], uint16(len(tiff)+len(jpegExifHeader)+2))
+		jpeg = append(append(jpeg, tiff...), 0xff, 0xda)
+		if result, err := readExifTime(bytes.NewReader(jpeg)); err == nil {
+			t.Errorf("%s: readExifTime() in jpeg = %v, want an error", name, result)
+		}
+	}
+	// Data cut anywhere may still hold a date, but must not crash
+	for cut := range len(valid) {
+		_, _ = exifTime(valid[:cut])
+	}
+}
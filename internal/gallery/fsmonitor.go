@@ -8,11 +8,16 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
+	"strings"
 	"time"
 
 	"github.com/charithe/timedbuf"
 	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
 )
 
 var (
@@ -62,36 +67,26 @@ func sendDiscord(jsonData discordMessage) {
 
 var slashes = regexp.MustCompile(`/`)
 
-func notify(items []any) {
+// Discord embed with the thumbnail of media by its path in the root folder
+func mediaEmbed(path string) discordEmbed {
+	return discordEmbed{
+		Title: filepath.Base(path),
+		Description: slashes.ReplaceAllString(filepath.ToSlash(
+			filepath.Dir(path)), " • "),
+		Url: config.Global.PublicUrl +
+			EscapePath(filepath.Dir(path)) +
+			"#" + EscapePath(filepath.Base(path)),
+		Image: discordImage{Url: config.Global.PublicUrl +
+			EscapePath(path) + "?" + config.TokenQuery(config.Global.DiscordUser, "thumb")},
+	}
+}
+
+func sendEmbeds(content string, embeds []discordEmbed) {
 	jsonData := discordMessage{
 		Username: config.Global.DiscordName,
+		Content:  content,
 		Embeds:   []discordEmbed{},
 	}
-	uniqueEmbeds := make(map[string]discordEmbed)
-
-	for _, item := range items {
-		sItem := fmt.Sprint(item)
-		if filepath.Ext(sItem) == "" || !IsValidMedia(sItem) {
-			// Ignore non files and non media files
-			continue
-		}
-		if path, err := filepath.Rel(config.Global.Root, sItem); err == nil {
-			uniqueEmbeds[path] = discordEmbed{
-				Title: filepath.Base(sItem),
-				Description: slashes.ReplaceAllString(filepath.ToSlash(
-					filepath.Dir(path)), " • "),
-				Url: config.Global.PublicUrl +
-					EscapePath(filepath.Dir(path)) +
-					"#" + EscapePath(filepath.Base(sItem)),
-				Image: discordImage{Url: config.Global.PublicUrl +
-					EscapePath(path) + "?thumb"},
-			}
-		}
-	}
-	embeds := make([]discordEmbed, 0, len(uniqueEmbeds))
-	for _, embed := range uniqueEmbeds {
-		embeds = append(embeds, embed)
-	}
 	totalEmbeds := len(embeds)
 	if totalEmbeds == 0 {
 		return
@@ -103,18 +98,106 @@ func notify(items []any) {
 		// only 10 embeds are allowed per message
 		for i := 0; i < totalEmbeds; i += maxEmbeds {
 			bound := min(i + maxEmbeds, totalEmbeds)
-			jsonData.Content = "New media in " + config.Global.PublicUrl
 			jsonData.Embeds = embeds[i:bound]
 			sendDiscord(jsonData)
 		}
 	} else {
-		jsonData.Content = "New media in " + config.Global.PublicUrl
 		jsonData.Embeds = embeds
 		sendDiscord(jsonData)
 	}
 }
 
-// Watches every folder in config.Global.Root and sends notification on new file
+// Folders the audience of notifications can see, those of the Discord user
+func notifyAccess() *config.FolderAccess {
+	return config.NewFolderAccess(config.Global.DiscordUser)
+}
+
+func notify(items []any) {
+	uniqueEmbeds := make(map[string]discordEmbed)
+	access := notifyAccess()
+
+	for _, item := range items {
+		sItem := fmt.Sprint(item)
+		if filepath.Ext(sItem) == "" || !IsValidMedia(sItem) {
+			// Ignore non files and non media files
+			continue
+		}
+		if path, err := filepath.Rel(config.Global.Root, sItem); err == nil &&
+			!IsExcluded(path, false) && access.AllowsPath(filepath.ToSlash(path), false) {
+			uniqueEmbeds[path] = mediaEmbed(path)
+		}
+	}
+	embeds := make([]discordEmbed, 0, len(uniqueEmbeds))
+	for _, embed := range uniqueEmbeds {
+		embeds = append(embeds, embed)
+	}
+	sendEmbeds("New media in "+config.Global.PublicUrl, embeds)
+}
+
+// Hour of the day when memories are sent
+var memoriesHour = 9
+
+// Most memories shown in a notification, the rest are on the linked page
+var maxMemoryEmbeds = 10
+
+// Sends the media taken on the day in earlier years
+func notifyMemories(date time.Time) {
+	access := notifyAccess()
+	entries := slices.DeleteFunc(Memories("", date, 0), func(entry IndexEntry) bool {
+		return !access.AllowsPath(entry.Path, false)
+	})
+	if len(entries) == 0 {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Taken().After(entries[j].Taken())
+	})
+	embeds := make([]discordEmbed, 0, min(len(entries), maxMemoryEmbeds))
+	for _, entry := range entries[:min(len(entries), maxMemoryEmbeds)] {
+		embeds = append(embeds, mediaEmbed(filepath.FromSlash(entry.Path)))
+	}
+	sendEmbeds(fmt.Sprintf("On this day: %v memories %v?onthisday",
+		len(entries), config.Global.PublicUrl), embeds)
+}
+
+// File in the cache folder with the day memories were last sent on, so
+// that restarts do not send them again
+var memoriesSentFile = "/.memories-sent"
+
+// Day memories were last sent on, empty when they never were
+func memoriesSentOn() string {
+	data, err := afero.ReadFile(storage.Cache, memoriesSentFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func setMemoriesSentOn(day string) {
+	err := afero.WriteFile(storage.Cache, memoriesSentFile, []byte(day+"\n"), 0o644)
+	if err != nil {
+		(*logger).Printf("error: cannot keep the day memories were sent on: %v", err)
+	}
+}
+
+// Sends a daily notification with memories once the hour has come
+func StartMemoriesNotifier() {
+	(*logger).Printf("Sending memories daily after %v:00", memoriesHour)
+	lastSent := memoriesSentOn()
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		now = now.In(config.Global.TimeLocation)
+		if today := now.Format(time.DateOnly); today != lastSent && now.Hour() >= memoriesHour {
+			lastSent = today
+			setMemoriesSentOn(today)
+			notifyMemories(now)
+		}
+	}
+}
+
+// Watches every folder in config.Global.Root to keep the search index current
+// and sends notification on new file (when a discord webhook is set)
 func StartFsWatcher() {
 	var err error
 	watcher, err = fsnotify.NewWatcher()
@@ -136,10 +219,31 @@ func StartFsWatcher() {
 				if !ok {
 					return
 				}
+				if name := filepath.Base(event.Name); name == IgnoreFileName ||
+					name == config.MetafileName {
+					indexRefresh(filepath.Dir(event.Name))
+					continue
+				}
+				if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					// Renamed files are created again with their new name
+					indexRemove(event.Name)
+					if relPath, ok := indexPath(event.Name); ok {
+						forgetCached(relPath)
+					}
+				}
+				if event.Op&fsnotify.Write == fsnotify.Write {
+					// Files being copied or edited change size and dates
+					if info, err := os.Stat(event.Name); err == nil && !info.IsDir() {
+						indexWritten(event.Name)
+					}
+				}
 				if event.Op&fsnotify.Create == fsnotify.Create {
-					eventBuffer.Put(event.Name)
-					newStat, _ := os.Stat(event.Name)
-					if newStat.IsDir() {
+					indexAdd(event.Name)
+					if config.Global.DiscordWebhook != "" {
+						eventBuffer.Put(event.Name)
+					}
+					newStat, err := os.Stat(event.Name)
+					if err == nil && newStat.IsDir() {
 						_ = watcher.Add(event.Name)
 						(*logger).Printf("Watching for new files in %v", event.Name)
 					}
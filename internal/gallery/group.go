@@ -0,0 +1,64 @@
+package gallery
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Groups files sharing a base name (e.g. RAW+JPEG and their .xmp sidecars).
+// Only extensions found in priority are grouped, the most preferred first.
+// The result maps the preferred media file of each group to the others.
+func GroupFiles(names []string, priority []string) map[string][]string {
+	rank := make(map[string]int, len(priority))
+	for i, ext := range priority {
+		ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+		if _, exists := rank[ext]; !exists {
+			rank[ext] = i
+		}
+	}
+	extRank := func(name string) (int, bool) {
+		r, ok := rank[strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))]
+		return r, ok
+	}
+
+	members := make(map[string][]string)
+	for _, name := range names {
+		if _, ok := extRank(name); !ok {
+			continue
+		}
+		// Sidecars can be named after the whole file, like photo.cr2.xmp
+		base := name
+		for {
+			if _, ok := extRank(base); !ok || filepath.Ext(base) == base {
+				break
+			}
+			base = strings.TrimSuffix(base, filepath.Ext(base))
+		}
+		base = strings.ToLower(base)
+		members[base] = append(members[base], name)
+	}
+
+	groups := make(map[string][]string)
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			ri, _ := extRank(group[i])
+			rj, _ := extRank(group[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return len(group[i]) < len(group[j])
+		})
+		for i, name := range group {
+			if IsValidMedia(name) {
+				alternates := append(append([]string{}, group[:i]...), group[i+1:]...)
+				groups[name] = alternates
+				break
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,33 @@
+package gallery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupFiles(t *testing.T) {
+	priority := []string{"jpg", "jpeg", "heic", "cr2", "xmp"}
+	tests := []struct {
+		names    []string
+		priority []string
+		want     map[string][]string
+	}{
+		{[]string{"IMG_1.CR2", "IMG_1.JPG", "IMG_2.JPG"}, priority,
+			map[string][]string{"IMG_1.JPG": {"IMG_1.CR2"}}},
+		{[]string{"a.cr2", "a.cr2.xmp", "a.heic", "a.jpg"}, priority,
+			map[string][]string{"a.jpg": {"a.heic", "a.cr2", "a.cr2.xmp"}}},
+		{[]string{"raw.cr2", "raw.xmp"}, priority,
+			map[string][]string{"raw.cr2": {"raw.xmp"}}},
+		{[]string{"b.jpg", "b.png", "b.mp4"}, priority, map[string][]string{}},
+		{[]string{"c.jpg", "c.cr2"}, []string{".CR2", ".JPG"},
+			map[string][]string{"c.cr2": {"c.jpg"}}},
+		{[]string{"d.xmp", "d.txt"}, priority, map[string][]string{}},
+		{[]string{"e.jpg", "e.cr2"}, nil, map[string][]string{}},
+	}
+	for _, tc := range tests {
+		if result := GroupFiles(tc.names, tc.priority); !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("GroupFiles(%v, %v) = %v, want %v",
+				tc.names, tc.priority, result, tc.want)
+		}
+	}
+}
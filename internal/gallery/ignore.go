@@ -0,0 +1,252 @@
+package gallery
+
+import (
+	"bufio"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+// Name of the files with patterns of hidden files and folders, like .gitignore
+var IgnoreFileName = ".foldergalignore"
+
+// Pattern of hidden files and folders
+type ignoreRule struct {
+	pattern  string // Glob, with slashes when anchored
+	base     string // Folder of the rule relative to the root, empty for all
+	anchored bool   // Matches the path from the base, not only the name
+	dirOnly  bool
+	negate   bool // Shows again what earlier rules hid
+}
+
+// Reads gitignore style patterns: comments start with #, ! shows again,
+// a slash at the end matches only folders, one at the start or in the
+// middle matches the path from the folder of the rule and ** any folders
+func parseIgnoreRules(base string, lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimLeft(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Checks if the rule matches a path relative to the root
+func (rule ignoreRule) match(relPath string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.base != "" {
+		if !strings.HasPrefix(relPath, rule.base+"/") {
+			return false
+		}
+		relPath = relPath[len(rule.base)+1:]
+	}
+	if !rule.anchored {
+		ok, _ := path.Match(rule.pattern, path.Base(relPath))
+		return ok
+	}
+	return matchSegments(strings.Split(rule.pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// Matches path segments with glob segments where ** matches any count of them
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+type cachedRules struct {
+	modTime time.Time
+	size    int64
+	rules   []ignoreRule
+}
+
+var (
+	ignoreCache   = make(map[string]cachedRules)
+	ignoreCacheMu sync.RWMutex
+)
+
+// Reads the ignore file of a folder, cached until it changes
+func ignoreFileRules(folder string) []ignoreRule {
+	fileName := path.Join("/", folder, IgnoreFileName)
+	stat, err := storage.Root.Stat(fileName)
+	if err != nil || stat.IsDir() {
+		return nil
+	}
+	ignoreCacheMu.RLock()
+	cached, ok := ignoreCache[folder]
+	ignoreCacheMu.RUnlock()
+	if ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.rules
+	}
+	var lines []string
+	if file, err := storage.Root.Open(fileName); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+	}
+	rules := parseIgnoreRules(folder, lines)
+	ignoreCacheMu.Lock()
+	ignoreCache[folder] = cachedRules{modTime: stat.ModTime(), size: stat.Size(), rules: rules}
+	ignoreCacheMu.Unlock()
+	return rules
+}
+
+// Rules set in a folder by its ignore file and settings
+func folderRules(folder string) []ignoreRule {
+	rules := ignoreFileRules(folder)
+	settings, err := config.OwnFolderSettings(path.Join("/", folder))
+	if err == nil && len(settings.Ignore) > 0 {
+		rules = append(rules, parseIgnoreRules(folder, settings.Ignore)...)
+	}
+	return rules
+}
+
+// IgnoreRules decide which children of a folder are hidden. They have the
+// global rules and those of the folder and its parents, the deepest last.
+type IgnoreRules struct {
+	folder string // Relative to the root, empty for the root
+	rules  []ignoreRule
+}
+
+// Rules for the children of the root folder
+func RootIgnoreRules() IgnoreRules {
+	rules := parseIgnoreRules("", config.Global.Ignore)
+	return IgnoreRules{rules: append(rules, folderRules("")...)}
+}
+
+// Rules for the children of a subfolder
+func (ir IgnoreRules) Sub(name string) IgnoreRules {
+	folder := path.Join(ir.folder, name)
+	rules := append(ir.rules[:len(ir.rules):len(ir.rules)], folderRules(folder)...)
+	return IgnoreRules{folder: folder, rules: rules}
+}
+
+// Excludes checks if a child of the folder is hidden by its name
+// starting with a dot or by the last matching rule
+func (ir IgnoreRules) Excludes(name string, isDir bool) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	relPath := path.Join(ir.folder, name)
+	excluded := false
+	for _, rule := range ir.rules {
+		if rule.negate == excluded && rule.match(relPath, isDir) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// IsExcluded checks if a file or folder is hidden from the gallery by
+// itself or by one of its parent folders. Paths are relative to the root.
+func IsExcluded(relPath string, isDir bool) bool {
+	relPath = strings.Trim(filepath.ToSlash(relPath), "/")
+	if relPath == "" || relPath == "." {
+		return false
+	}
+	iw := NewIgnoreWalker()
+	segments := strings.Split(relPath, "/")
+	for i := range segments {
+		last := i == len(segments)-1
+		if iw.Excludes(strings.Join(segments[:i+1], "/"), isDir || !last) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExcludedPath is IsExcluded for a path which might be a folder
+func IsExcludedPath(relPath string) bool {
+	stat, err := storage.Root.Stat(relPath)
+	return IsExcluded(relPath, err == nil && stat.IsDir())
+}
+
+// Rules for the children of a folder relative to the root
+func folderIgnoreRules(folder string) IgnoreRules {
+	rules := RootIgnoreRules()
+	for name := range strings.SplitSeq(folder, "/") {
+		if name != "" && name != "." {
+			rules = rules.Sub(name)
+		}
+	}
+	return rules
+}
+
+// IgnoreWalker checks entries met while walking folders top down, reading
+// the rules of each folder once. Hidden folders should be skipped.
+type IgnoreWalker struct {
+	folders map[string]IgnoreRules
+}
+
+func NewIgnoreWalker() *IgnoreWalker {
+	return &IgnoreWalker{folders: make(map[string]IgnoreRules)}
+}
+
+// Excludes checks if an entry with a path relative to the root is hidden
+func (iw *IgnoreWalker) Excludes(relPath string, isDir bool) bool {
+	relPath = strings.Trim(filepath.ToSlash(relPath), "/")
+	if relPath == "" || relPath == "." {
+		return false
+	}
+	parent := path.Dir(relPath)
+	if parent == "." {
+		parent = ""
+	}
+	rules, ok := iw.folders[parent]
+	if !ok {
+		rules = folderIgnoreRules(parent)
+		iw.folders[parent] = rules
+	}
+	name := path.Base(relPath)
+	if rules.Excludes(name, isDir) {
+		return true
+	}
+	if isDir {
+		iw.folders[relPath] = rules.Sub(name)
+	}
+	return false
+}
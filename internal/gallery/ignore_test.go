@@ -0,0 +1,88 @@
+package gallery
+
+import (
+	"testing"
+
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
+
+	"github.com/spf13/afero"
+)
+
+func TestIgnoreRuleMatch(t *testing.T) {
+	tests := []struct {
+		line, base, path string
+		isDir, want      bool
+	}{
+		{"*.tmp", "", "a/b/upload.tmp", false, true},
+		{"*.tmp", "", "a/b/upload.jpg", false, false},
+		{"@eaDir/", "", "photos/@eaDir", true, true},
+		{"@eaDir/", "", "photos/@eaDir", false, false},
+		{"/private", "trip", "trip/private", true, true},
+		{"/private", "trip", "trip/day1/private", true, false},
+		{"private", "trip", "trip/day1/private", true, true},
+		{"private", "trip", "home/private", true, false},
+		{"day*/raw", "trip", "trip/day2/raw", true, true},
+		{"**/raw", "", "a/b/c/raw", true, true},
+		{"a/**/x.jpg", "", "a/x.jpg", false, true},
+		{"a/**/x.jpg", "", "a/b/c/x.jpg", false, true},
+		{"a/**/x.jpg", "", "b/x.jpg", false, false},
+	}
+	for _, tc := range tests {
+		rules := parseIgnoreRules(tc.base, []string{tc.line})
+		if len(rules) != 1 {
+			t.Fatalf("parseIgnoreRules(%q) = %v", tc.line, rules)
+		}
+		if result := rules[0].match(tc.path, tc.isDir); result != tc.want {
+			t.Errorf("%q in %q matches %q = %v, want %v",
+				tc.line, tc.base, tc.path, result, tc.want)
+		}
+	}
+	if rules := parseIgnoreRules("", []string{"", "# comment", "/", "!"}); len(rules) != 0 {
+		t.Errorf("parseIgnoreRules() of no patterns = %v", rules)
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	root, ignore := storage.Root, config.Global.Ignore
+	defer func() { storage.Root, config.Global.Ignore = root, ignore }()
+	storage.Root = afero.NewMemMapFs()
+	config.Global.Ignore = config.JsonList{"Thumbs.db", "@eaDir/"}
+	files := map[string]string{
+		"/" + IgnoreFileName:                "*.tmp\nprivate/\n",
+		"/trip/" + IgnoreFileName:           "!keep.tmp\n/raw\n",
+		"/trip/day1/" + config.MetafileName: "ignore: ['*.png', '!a.png']",
+	}
+	for name, data := range files {
+		if err := afero.WriteFile(storage.Root, name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"/", true, false},
+		{"trip/day1/a.jpg", false, false},
+		{"trip/.hidden/a.jpg", false, true},
+		{"a/Thumbs.db", false, true},
+		{"a/@eaDir", true, true},
+		{"a/@eaDir/x.jpg", false, true},
+		{"upload.tmp", false, true},
+		{"trip/keep.tmp", false, false},
+		{"trip/day1/keep.tmp", false, false},
+		{"private/a.jpg", false, true},
+		{"trip/private", true, true},
+		{"trip/raw/a.cr2", false, true},
+		{"trip/day1/raw/a.cr2", false, false},
+		{"trip/day1/b.png", false, true},
+		{"trip/day1/a.png", false, false},
+		{"trip/day2/b.png", false, false},
+	}
+	for _, tc := range tests {
+		if result := IsExcluded(tc.path, tc.isDir); result != tc.want {
+			t.Errorf("IsExcluded(%q, %v) = %v, want %v", tc.path, tc.isDir, result, tc.want)
+		}
+	}
+}
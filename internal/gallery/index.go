@@ -0,0 +1,273 @@
+package gallery
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
+
+	"github.com/fvbommel/sortorder"
+	"github.com/spf13/afero"
+)
+
+// Indexed file or folder
+type IndexEntry struct {
+	ModTime  time.Time
+	Captured time.Time // Capture time of photos from EXIF, zero when unknown
+	Path     string    // Relative to the root folder, with forward slashes
+	Size     int64
+	IsDir    bool
+	lower    string // Lower case name for matching
+}
+
+func (e IndexEntry) Name() string { return path.Base(e.Path) }
+
+var (
+	index   = make(map[string]IndexEntry)
+	indexMu sync.RWMutex
+)
+
+// Count of indexed files and folders
+func IndexSize() int {
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+	return len(index)
+}
+
+// Indexed media files in a folder and its subfolders (not sorted)
+func IndexFiles(scope string) []IndexEntry {
+	scope = strings.Trim(scope, "/")
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+	entries := make([]IndexEntry, 0, len(index))
+	for key, entry := range index {
+		if !entry.IsDir && (scope == "" || strings.HasPrefix(key, scope+"/")) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// BuildIndex reads the names of all media and folders in the root folder
+func BuildIndex() {
+	entries := make(map[string]IndexEntry)
+	walkIndex("", entries)
+	indexMu.Lock()
+	index = entries
+	indexMu.Unlock()
+	(*logger).Printf("Indexed %v files and folders", len(entries))
+}
+
+func walkIndex(start string, entries map[string]IndexEntry) {
+	ignored := NewIgnoreWalker()
+	err := afero.Walk(storage.Root, "/"+start,
+		func(walkPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath := strings.TrimPrefix(filepath.ToSlash(walkPath), "/")
+			if relPath == "" {
+				return nil
+			}
+			if ignored.Excludes(relPath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || IsValidMedia(relPath) {
+				entries[relPath] = newIndexEntry(relPath, info)
+			}
+			return nil
+		})
+	if err != nil {
+		(*logger).Print(err)
+	}
+}
+
+func newIndexEntry(relPath string, info os.FileInfo) IndexEntry {
+	entry := IndexEntry{
+		ModTime: info.ModTime(),
+		Path:    relPath,
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		lower:   strings.ToLower(path.Base(relPath)),
+	}
+	if !info.IsDir() {
+		entry.Captured = readCaptureTime("/" + relPath)
+	}
+	return entry
+}
+
+// Converts a path reported by the file system to a path in the index
+func indexPath(osPath string) (string, bool) {
+	relPath, err := filepath.Rel(config.Global.Root, osPath)
+	if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(relPath), true
+}
+
+// Adds a new file or a folder with its contents to the index
+func indexAdd(osPath string) {
+	relPath, ok := indexPath(osPath)
+	if !ok {
+		return
+	}
+	info, err := storage.Root.Stat(relPath)
+	if err != nil || IsExcluded(relPath, info.IsDir()) {
+		return
+	}
+	entries := make(map[string]IndexEntry)
+	if info.IsDir() {
+		walkIndex(relPath, entries)
+	} else if IsValidMedia(relPath) {
+		indexMu.RLock()
+		indexed, found := index[relPath]
+		indexMu.RUnlock()
+		if found && indexed.Size == info.Size() && indexed.ModTime.Equal(info.ModTime()) {
+			return // Unchanged, its EXIF is not read again
+		}
+		entries[relPath] = newIndexEntry(relPath, info)
+	}
+	indexMu.Lock()
+	for key, entry := range entries {
+		index[key] = entry
+	}
+	indexMu.Unlock()
+}
+
+// How long a file is left alone after it is written before it is indexed
+// again, since copies write a file many times
+var indexWriteDelay = 2 * time.Second
+
+var (
+	pendingWrites   = make(map[string]*time.Timer)
+	pendingWritesMu sync.Mutex
+)
+
+// Indexes a written file again once it is no longer being written
+func indexWritten(osPath string) {
+	pendingWritesMu.Lock()
+	defer pendingWritesMu.Unlock()
+	if timer, ok := pendingWrites[osPath]; ok {
+		timer.Reset(indexWriteDelay)
+		return
+	}
+	pendingWrites[osPath] = time.AfterFunc(indexWriteDelay, func() {
+		pendingWritesMu.Lock()
+		delete(pendingWrites, osPath)
+		pendingWritesMu.Unlock()
+		indexAdd(osPath)
+	})
+}
+
+// Removes a file or a folder with its contents from the index
+func indexRemove(osPath string) {
+	relPath, ok := indexPath(osPath)
+	if !ok {
+		return
+	}
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	if entry, found := index[relPath]; found && !entry.IsDir {
+		delete(index, relPath)
+		return
+	}
+	for key := range index {
+		if key == relPath || strings.HasPrefix(key, relPath+"/") {
+			delete(index, key)
+		}
+	}
+}
+
+// How well a name matches a search, lower is better
+func matchScore(name, term string) (int, bool) {
+	if strings.ContainsAny(term, "*?[") {
+		if matched, _ := path.Match(term, name); matched {
+			return 2, true
+		}
+		return 0, false
+	}
+	switch i := strings.Index(name, term); {
+	case i < 0:
+	case len(name) == len(term):
+		return 0, true
+	case i == 0:
+		return 1, true
+	default:
+		return 2, true
+	}
+	// Fuzzy: the letters of term appear in order, the closer the better
+	if len([]rune(term)) < 3 {
+		return 0, false
+	}
+	start, pos := -1, 0
+	for _, r := range term {
+		i := strings.IndexRune(name[pos:], r)
+		if i < 0 {
+			return 0, false
+		}
+		if start < 0 {
+			start = pos + i
+		}
+		pos += i + len(string(r))
+	}
+	return 3 + pos - start - len(term), true
+}
+
+// Search finds files and folders with names matching term in the folder
+// scope (empty for everything). Terms with wildcards are globs, others match
+// part of a name or its letters in order. Best matches are first.
+func Search(term, scope string, limit int) []IndexEntry {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if term == "" {
+		return nil
+	}
+	scope = strings.Trim(scope, "/")
+	type result struct {
+		entry IndexEntry
+		score int
+	}
+	var results []result
+	indexMu.RLock()
+	for key, entry := range index {
+		if scope != "" && !strings.HasPrefix(key, scope+"/") {
+			continue
+		}
+		if score, ok := matchScore(entry.lower, term); ok {
+			results = append(results, result{entry, score})
+		}
+	}
+	indexMu.RUnlock()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score < results[j].score
+		}
+		return sortorder.NaturalLess(results[i].entry.lower, results[j].entry.lower)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	entries := make([]IndexEntry, 0, len(results))
+	for _, r := range results {
+		entries = append(entries, r.entry)
+	}
+	return entries
+}
+
+// Indexes a folder again when the files it hides could have changed
+func indexRefresh(osFolder string) {
+	if _, ok := indexPath(osFolder); !ok {
+		BuildIndex()
+		return
+	}
+	indexRemove(osFolder)
+	indexAdd(osFolder)
+}
@@ -0,0 +1,115 @@
+package gallery
+
+import (
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
+
+	"github.com/spf13/afero"
+)
+
+func TestMatchScore(t *testing.T) {
+	tests := []struct {
+		name, term string
+		score      int
+		ok         bool
+	}{
+		{"beach.jpg", "beach.jpg", 0, true},
+		{"beach.jpg", "beach", 1, true},
+		{"my beach.jpg", "beach", 2, true},
+		{"beach.jpg", "*.jpg", 2, true},
+		{"beach.png", "*.jpg", 0, false},
+		{"img_0001.jpg", "img_000?.jpg", 2, true},
+		{"day2", "dy2", 4, true},
+		{"beach.jpg", "bhj", 7, true},
+		{"beach.jpg", "bj", 0, false},
+		{"beach.jpg", "xyz", 0, false},
+	}
+	for _, tc := range tests {
+		score, ok := matchScore(tc.name, tc.term)
+		if ok != tc.ok || (ok && score != tc.score) {
+			t.Errorf("matchScore(%q, %q) = %v, %v, want %v, %v",
+				tc.name, tc.term, score, ok, tc.score, tc.ok)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index = make(map[string]IndexEntry)
+	for _, p := range []string{"trip", "trip/beach.jpg", "trip/sea beach.png",
+		"home/beach", "home/cat.jpg", "trip/best ache.jpg"} {
+		index[p] = IndexEntry{Path: p, lower: strings.ToLower(path.Base(p))}
+	}
+	paths := func(entries []IndexEntry) (result []string) {
+		for _, entry := range entries {
+			result = append(result, entry.Path)
+		}
+		return
+	}
+	tests := []struct {
+		term, scope string
+		limit       int
+		want        []string
+	}{
+		{"Beach", "", 0, []string{"home/beach", "trip/beach.jpg",
+			"trip/sea beach.png", "trip/best ache.jpg"}},
+		{"beach", "/trip/", 0, []string{"trip/beach.jpg",
+			"trip/sea beach.png", "trip/best ache.jpg"}},
+		{"*.jpg", "", 2, []string{"trip/beach.jpg", "trip/best ache.jpg"}},
+		{" ", "", 0, nil},
+		{"dog", "", 0, nil},
+	}
+	for _, tc := range tests {
+		result := paths(Search(tc.term, tc.scope, tc.limit))
+		if !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("Search(%q, %q) = %v, want %v", tc.term, tc.scope, result, tc.want)
+		}
+	}
+}
+
+func TestIndexWritten(t *testing.T) {
+	root, rootPath, delay := storage.Root, config.Global.Root, indexWriteDelay
+	defer func() {
+		storage.Root, config.Global.Root, indexWriteDelay = root, rootPath, delay
+	}()
+	storage.Root = afero.NewMemMapFs()
+	config.Global.Root = filepath.FromSlash("/gallery")
+	indexWriteDelay = 50 * time.Millisecond
+	index = make(map[string]IndexEntry)
+	osPath := filepath.Join(config.Global.Root, "a.jpg")
+	indexed := func() (IndexEntry, bool) {
+		indexMu.RLock()
+		defer indexMu.RUnlock()
+		entry, ok := index["a.jpg"]
+		return entry, ok
+	}
+
+	for _, data := range []string{"a", "ab", "abc"} {
+		if err := afero.WriteFile(storage.Root, "a.jpg", []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		indexWritten(osPath)
+	}
+	if entry, ok := indexed(); ok {
+		t.Errorf("file being written is indexed: %+v", entry)
+	}
+	time.Sleep(4 * indexWriteDelay)
+	if entry, ok := indexed(); !ok || entry.Size != 3 {
+		t.Errorf("written file is indexed as %+v, %v, want its last size", entry, ok)
+	}
+
+	// Unchanged files keep their entry
+	entry, _ := indexed()
+	entry.Captured = time.Date(2020, 5, 3, 12, 0, 0, 0, time.UTC)
+	index["a.jpg"] = entry
+	indexAdd(osPath)
+	if result, _ := indexed(); !result.Captured.Equal(entry.Captured) {
+		t.Errorf("unchanged file is read again: %+v", result)
+	}
+}
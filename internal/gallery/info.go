@@ -0,0 +1,110 @@
+package gallery
+
+import (
+	"context"
+	"image"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"specto.org/projects/foldergal/internal/config"
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+// Technical details of media which are known only after reading it
+type MediaInfo struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+}
+
+var reVideoSize = regexp.MustCompile(`Video: .*?, (\d{2,5})x(\d{2,5})`)
+
+// How long ffmpeg may take to read the details of a video or audio file
+const movieInfoTimeout = 10 * time.Second
+
+type cachedInfo struct {
+	modTime time.Time
+	info    MediaInfo
+}
+
+var (
+	infoCache   = make(map[string]cachedInfo)
+	infoCacheMu sync.RWMutex
+)
+
+// ReadInfo gets the dimensions of images in formats we can decode and,
+// with ffmpeg, the dimensions and duration of video and audio.
+// Results are cached until the file changes, unless the context ended
+// before they were read.
+func ReadInfo(ctx context.Context, fullPath string) MediaInfo {
+	fileInfo, err := storage.Root.Stat(fullPath)
+	if err != nil || fileInfo.IsDir() {
+		return MediaInfo{}
+	}
+	infoCacheMu.RLock()
+	cached, ok := infoCache[fullPath]
+	infoCacheMu.RUnlock()
+	if ok && cached.modTime.Equal(fileInfo.ModTime()) {
+		return cached.info
+	}
+
+	var info MediaInfo
+	switch GetMediaClass(fullPath) {
+	case MediaImage:
+		info = readImageInfo(fullPath)
+	case MediaVideo, MediaAudio:
+		info = readMovieInfo(ctx, fullPath)
+	}
+	if ctx.Err() != nil {
+		return info
+	}
+	infoCacheMu.Lock()
+	infoCache[fullPath] = cachedInfo{modTime: fileInfo.ModTime(), info: info}
+	infoCacheMu.Unlock()
+	return info
+}
+
+func readImageInfo(fullPath string) (info MediaInfo) {
+	file, err := storage.Root.Open(fullPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	imgConfig, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return
+	}
+	info.Width = imgConfig.Width
+	info.Height = imgConfig.Height
+	return
+}
+
+func readMovieInfo(ctx context.Context, fullPath string) (info MediaInfo) {
+	if config.Global.Ffmpeg == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, movieInfoTimeout)
+	defer cancel()
+	// Without an output file ffmpeg only prints what it knows of the input
+	cmd := exec.CommandContext(ctx, config.Global.Ffmpeg,
+		"-hide_banner",
+		"-i", filepath.Join(config.Global.Root, fullPath)) // #nosec Executable path is provided by config
+	out, _ := cmd.CombinedOutput()
+	return parseMovieInfo(out)
+}
+
+// Reads the duration and video size from the output of ffmpeg
+func parseMovieInfo(out []byte) (info MediaInfo) {
+	if match := reDuration.FindSubmatch(out); match != nil {
+		info.Duration = fromTimeCode(string(match[1]))
+	}
+	if match := reVideoSize.FindSubmatch(out); match != nil {
+		info.Width, _ = strconv.Atoi(string(match[1]))
+		info.Height, _ = strconv.Atoi(string(match[2]))
+	}
+	return
+}
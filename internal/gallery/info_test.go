@@ -0,0 +1,29 @@
+package gallery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMovieInfo(t *testing.T) {
+	tests := []struct {
+		out  string
+		want MediaInfo
+	}{
+		{"", MediaInfo{}},
+		{`Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'clip.mov':
+  Duration: 00:01:05.23, start: 0.000000, bitrate: 8041 kb/s
+  Stream #0:0[0x1](und): Video: h264 (High) (avc1 / 0x31637661), yuv420p(tv, bt709, progressive), 1920x1080, 7900 kb/s, 29.97 fps
+  Stream #0:1[0x2](und): Audio: aac (LC) (mp4a / 0x6134706D), 44100 Hz, stereo, fltp, 128 kb/s`,
+			MediaInfo{Width: 1920, Height: 1080, Duration: time.Minute + 5*time.Second}},
+		{`Input #0, mp3, from 'song.mp3':
+  Duration: 01:02:03.00, start: 0.025057, bitrate: 320 kb/s
+  Stream #0:0: Audio: mp3, 44100 Hz, stereo, fltp, 320 kb/s`,
+			MediaInfo{Duration: time.Hour + 2*time.Minute + 3*time.Second}},
+	}
+	for _, tc := range tests {
+		if result := parseMovieInfo([]byte(tc.out)); result != tc.want {
+			t.Errorf("parseMovieInfo(%q) = %+v, want %+v", tc.out, result, tc.want)
+		}
+	}
+}
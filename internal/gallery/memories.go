@@ -0,0 +1,46 @@
+package gallery
+
+import (
+	"math"
+	"time"
+)
+
+// Days before and after a date with media shown as memories of its week
+const MemoriesWeekDays = 3
+
+// Taken is when the media was taken: the capture time of photos
+// when known, else the modification time
+func (e IndexEntry) Taken() time.Time {
+	if !e.Captured.IsZero() {
+		return e.Captured
+	}
+	return e.ModTime
+}
+
+// Memories finds indexed media in a folder and its subfolders taken on the
+// day of the year of date, or up to days around it, in earlier years
+func Memories(scope string, date time.Time, days int) []IndexEntry {
+	var entries []IndexEntry
+	for _, entry := range IndexFiles(scope) {
+		if isAnniversary(entry.Taken(), date, days) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Checks if taken is up to days around the same day of the year as date
+// in an earlier year
+func isAnniversary(taken, date time.Time, days int) bool {
+	location := date.Location()
+	taken = taken.In(location)
+	day := time.Date(taken.Year(), taken.Month(), taken.Day(), 0, 0, 0, 0, location)
+	// Days around new year are close to the anniversary in the next year
+	for year := taken.Year() - 1; year <= taken.Year()+1 && year < date.Year(); year++ {
+		anniversary := time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, location)
+		if math.Abs(math.Round(day.Sub(anniversary).Hours()/24)) <= float64(days) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package gallery
+
+import (
+	"testing"
+	"time"
+
+	"specto.org/projects/foldergal/internal/storage"
+
+	"github.com/spf13/afero"
+)
+
+func TestIsAnniversary(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		taken, date time.Time
+		days        int
+		want        bool
+	}{
+		{date(2020, 5, 3), date(2024, 5, 3), 0, true},
+		{date(2020, 5, 4), date(2024, 5, 3), 0, false},
+		{date(2024, 5, 3), date(2024, 5, 3), 0, false},
+		{date(2025, 5, 3), date(2024, 5, 3), 0, false},
+		{date(2020, 5, 6), date(2024, 5, 3), MemoriesWeekDays, true},
+		{date(2020, 5, 7), date(2024, 5, 3), MemoriesWeekDays, false},
+		{date(2022, 12, 30), date(2024, 1, 1), MemoriesWeekDays, true},
+		{date(2023, 12, 30), date(2024, 1, 1), MemoriesWeekDays, false},
+		{date(2023, 1, 2), date(2023, 12, 31), MemoriesWeekDays, true},
+		{date(2023, 12, 29), date(2023, 12, 31), MemoriesWeekDays, false},
+		{date(2023, 3, 1), date(2024, 2, 29), 0, true},
+	}
+	for _, tc := range tests {
+		if result := isAnniversary(tc.taken, tc.date, tc.days); result != tc.want {
+			t.Errorf("isAnniversary(%v, %v, %v) = %v, want %v",
+				tc.taken.Format(time.DateOnly), tc.date.Format(time.DateOnly),
+				tc.days, result, tc.want)
+		}
+	}
+}
+
+func TestTaken(t *testing.T) {
+	modified := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	captured := time.Date(2020, 5, 3, 12, 0, 0, 0, time.UTC)
+	if taken := (IndexEntry{ModTime: modified}).Taken(); !taken.Equal(modified) {
+		t.Errorf("Taken() without capture time = %v, want %v", taken, modified)
+	}
+	entry := IndexEntry{ModTime: modified, Captured: captured}
+	if taken := entry.Taken(); !taken.Equal(captured) {
+		t.Errorf("Taken() = %v, want %v", taken, captured)
+	}
+}
+
+func TestMemoriesSentOn(t *testing.T) {
+	cache := storage.Cache
+	defer func() { storage.Cache = cache }()
+	storage.Cache = afero.NewMemMapFs()
+	if day := memoriesSentOn(); day != "" {
+		t.Errorf("memoriesSentOn() before sending = %q", day)
+	}
+	setMemoriesSentOn("2024-05-03")
+	if day := memoriesSentOn(); day != "2024-05-03" {
+		t.Errorf("memoriesSentOn() = %q, want 2024-05-03", day)
+	}
+}
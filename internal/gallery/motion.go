@@ -0,0 +1,175 @@
+package gallery
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"specto.org/projects/foldergal/internal/storage"
+
+	"github.com/spf13/afero"
+)
+
+var (
+	reMicroVideoOffset = regexp.MustCompile(`MicroVideoOffset\s*=\s*"(\d+)"`)
+	reContainerItem    = regexp.MustCompile(`<Container:Item\b[^>]*>`)
+	reItemLength       = regexp.MustCompile(`Item:Length\s*=\s*"(\d+)"`)
+)
+
+// Pairs still images with videos sharing the same base name (Live Photos).
+// The result maps the name of each still to the name of its clip.
+func PairLivePhotos(names []string) map[string]string {
+	stills := make(map[string][]string)
+	clips := make(map[string][]string)
+	for _, name := range names {
+		base := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+		switch GetMediaClass(name) {
+		case MediaImage:
+			stills[base] = append(stills[base], name)
+		case MediaVideo:
+			clips[base] = append(clips[base], name)
+		}
+	}
+	pairs := make(map[string]string)
+	for base, videos := range clips {
+		images, ok := stills[base]
+		if !ok {
+			continue
+		}
+		sort.Strings(videos) // Be predictable when there are several
+		for _, still := range images {
+			pairs[still] = videos[0]
+		}
+	}
+	return pairs
+}
+
+// Finds the length of a video appended to a motion photo from its XMP packet.
+// Returns 0 if the packet does not describe such a video.
+func motionVideoLength(head []byte) int64 {
+	xmp := xmpFromBytes(head)
+	if xmp == nil {
+		return 0
+	}
+	// Older format: GCamera:MicroVideoOffset is counted from the end of file
+	if match := reMicroVideoOffset.FindSubmatch(xmp); match != nil {
+		length, _ := strconv.ParseInt(string(match[1]), 10, 64)
+		return length
+	}
+	// Motion Photo format: the video is a container item at the end of file
+	for _, item := range reContainerItem.FindAll(xmp, -1) {
+		if !bytes.Contains(item, []byte(`Item:Semantic="MotionPhoto"`)) {
+			continue
+		}
+		if match := reItemLength.FindSubmatch(item); match != nil {
+			length, _ := strconv.ParseInt(string(match[1]), 10, 64)
+			return length
+		}
+	}
+	return 0
+}
+
+// Checks that an mp4 (or any ISO media) box starts at offset
+func isMp4At(file io.ReaderAt, offset int64) bool {
+	box := make([]byte, 8)
+	if _, err := file.ReadAt(box, offset); err != nil {
+		return false
+	}
+	return string(box[4:]) == "ftyp"
+}
+
+// MARK -
+
+type cachedOffset struct {
+	modTime time.Time
+	offset  int64
+}
+
+// Offsets of the videos in motion photos, 0 for other images
+var (
+	motionCache   = make(map[string]cachedOffset)
+	motionCacheMu sync.RWMutex
+)
+
+// Finds where the video embedded in a motion photo starts, 0 when there is
+// none. Results are cached until the file changes since every image shown
+// is checked.
+func motionOffset(fullPath string, fileInfo os.FileInfo) int64 {
+	motionCacheMu.RLock()
+	cached, ok := motionCache[fullPath]
+	motionCacheMu.RUnlock()
+	if ok && cached.modTime.Equal(fileInfo.ModTime()) {
+		return cached.offset
+	}
+
+	file, err := storage.Root.Open(fullPath)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	length := motionVideoLength(readXmpPacket(file))
+	offset := fileInfo.Size() - length
+	if length <= 0 || offset <= 0 || !isMp4At(file, offset) {
+		offset = 0
+	}
+	motionCacheMu.Lock()
+	motionCache[fullPath] = cachedOffset{modTime: fileInfo.ModTime(), offset: offset}
+	motionCacheMu.Unlock()
+	return offset
+}
+
+type motionFile struct {
+	mediaFile
+	offset int64
+}
+
+// NewMotionPhoto prepares the extraction of the video embedded in a motion
+// photo. The extracted clip takes the place of the thumbnail in the cache.
+func NewMotionPhoto(fullPath, clipPath string) (Media, error) {
+	fileInfo, err := storage.Root.Stat(fullPath)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	if fileInfo.IsDir() || GetMediaClass(fullPath) != MediaImage {
+		return nil, ErrNotValid
+	}
+	offset := motionOffset(fullPath, fileInfo)
+	if offset <= 0 {
+		return nil, ErrNotValid
+	}
+	return &motionFile{mediaFile{
+		fullPath: fullPath, fileInfo: fileInfo, thumbPath: clipPath}, offset}, nil
+}
+
+func (f *motionFile) thumbGenerate() (err error) {
+	var file afero.File
+	file, err = storage.Root.Open(f.fullPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if _, err = file.Seek(f.offset, io.SeekStart); err != nil {
+		return
+	}
+	err = storage.Cache.MkdirAll(filepath.Dir(f.thumbPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+	newFile, err := storage.Cache.Create(f.thumbPath)
+	if err != nil {
+		return
+	}
+	defer newFile.Close()
+	if _, err = io.Copy(newFile, file); err != nil {
+		return
+	}
+	f.thumbInfo, err = storage.Cache.Stat(f.thumbPath)
+	return
+}
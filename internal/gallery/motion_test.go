@@ -0,0 +1,84 @@
+package gallery
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"specto.org/projects/foldergal/internal/storage"
+
+	"github.com/spf13/afero"
+)
+
+func TestPairLivePhotos(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  map[string]string
+	}{
+		{[]string{"IMG_1234.HEIC", "IMG_1234.MOV", "IMG_1235.HEIC"},
+			map[string]string{"IMG_1234.HEIC": "IMG_1234.MOV"}},
+		{[]string{"img_1.jpg", "IMG_1.mov", "img_1.mp4"},
+			map[string]string{"img_1.jpg": "IMG_1.mov"}},
+		{[]string{"a.jpg", "a.mp3", "b.mp4"}, map[string]string{}},
+		{[]string{"a.jpg", "a.png", "a.mp4"},
+			map[string]string{"a.jpg": "a.mp4", "a.png": "a.mp4"}},
+		{nil, map[string]string{}},
+	}
+	for _, tc := range tests {
+		if result := PairLivePhotos(tc.names); !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("PairLivePhotos(%v) = %v, want %v", tc.names, result, tc.want)
+		}
+	}
+}
+
+func TestMotionVideoLength(t *testing.T) {
+	tests := []struct {
+		head string
+		want int64
+	}{
+		{"", 0},
+		{"\xff\xd8 no xmp here MicroVideoOffset=\"100\"", 0},
+		{`<x:xmpmeta><rdf:Description GCamera:MicroVideo="1"
+			GCamera:MicroVideoOffset="2541"/></x:xmpmeta>`, 2541},
+		{`<x:xmpmeta><Container:Directory><rdf:Seq>
+			<rdf:li><Container:Item Item:Mime="image/jpeg" Item:Semantic="Primary" Item:Length="0"/></rdf:li>
+			<rdf:li><Container:Item Item:Mime="video/mp4" Item:Semantic="MotionPhoto" Item:Length="8831"/></rdf:li>
+			</rdf:Seq></Container:Directory></x:xmpmeta>`, 8831},
+		{`<x:xmpmeta><Container:Item Item:Semantic="Primary" Item:Length="10"/></x:xmpmeta>`, 0},
+		{`<x:xmpmeta></x:xmpmeta> MicroVideoOffset="5"`, 0},
+	}
+	for _, tc := range tests {
+		if result := motionVideoLength([]byte(tc.head)); result != tc.want {
+			t.Errorf("motionVideoLength(%q) = %v, want %v", tc.head, result, tc.want)
+		}
+	}
+}
+
+func TestNewMotionPhoto(t *testing.T) {
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = afero.NewMemMapFs()
+	clip := "\x00\x00\x00\x08ftypmp42"
+	photo := `<x:xmpmeta><rdf:Description GCamera:MicroVideoOffset="12"/></x:xmpmeta>` + clip
+	if err := afero.WriteFile(storage.Root, "/a.jpg", []byte(photo), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	media, err := NewMotionPhoto("/a.jpg", "/a.mp4")
+	if err != nil {
+		t.Fatalf("NewMotionPhoto() error = %v", err)
+	}
+	if offset := media.(*motionFile).offset; offset != int64(len(photo)-len(clip)) {
+		t.Errorf("NewMotionPhoto() offset = %v, want %v", offset, len(photo)-len(clip))
+	}
+	// Edited photos are checked again
+	if err := afero.WriteFile(storage.Root, "/a.jpg", []byte("plain"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := storage.Root.Chtimes("/a.jpg", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMotionPhoto("/a.jpg", "/a.mp4"); err != ErrNotValid {
+		t.Errorf("NewMotionPhoto() of an edited photo error = %v, want %v", err, ErrNotValid)
+	}
+}
@@ -0,0 +1,292 @@
+package gallery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+const (
+	nsRdf = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDc  = "http://purl.org/dc/elements/1.1/"
+	nsXmp = "http://ns.adobe.com/xap/1.0/"
+)
+
+// How much from the start of non-JPEG files is searched for an XMP packet
+var xmpHeadSize = 128 * 1024
+
+var jpegXmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// Descriptive metadata of media as written by Lightroom, darktable, etc.
+type Metadata struct {
+	Title    string
+	Caption  string
+	Keywords []string
+	Rating   int
+}
+
+type cachedMetadata struct {
+	fileTime    time.Time
+	sidecarTime time.Time
+	meta        Metadata
+}
+
+var (
+	metadataCache   = make(map[string]cachedMetadata)
+	metadataCacheMu sync.RWMutex
+)
+
+// Maps media files to their XMP sidecars, which are named either
+// photo.xmp or photo.jpg.xmp
+func FindSidecars(names []string) map[string]string {
+	xmps := make(map[string]string)
+	for _, name := range names {
+		if strings.EqualFold(filepath.Ext(name), ".xmp") {
+			xmps[strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))] = name
+		}
+	}
+	sidecars := make(map[string]string)
+	if len(xmps) == 0 {
+		return sidecars
+	}
+	for _, name := range names {
+		if !IsValidMedia(name) {
+			continue
+		}
+		lower := strings.ToLower(name)
+		if sidecar, ok := xmps[lower]; ok {
+			sidecars[name] = sidecar
+		} else if sidecar, ok := xmps[strings.TrimSuffix(lower, filepath.Ext(lower))]; ok {
+			sidecars[name] = sidecar
+		}
+	}
+	return sidecars
+}
+
+// Finds the XMP sidecar of a media file by checking its possible names
+func FindSidecar(fullPath string) string {
+	base := strings.TrimSuffix(fullPath, filepath.Ext(fullPath))
+	for _, candidate := range []string{fullPath + ".xmp", fullPath + ".XMP",
+		base + ".xmp", base + ".XMP"} {
+		if info, err := storage.Root.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// ReadMetadata gets the XMP metadata of a media file. The sidecar file is
+// preferred when given, otherwise the XMP packet embedded in the file is used.
+// Results are cached until one of the files changes.
+func ReadMetadata(fullPath, sidecarPath string) Metadata {
+	fileInfo, err := storage.Root.Stat(fullPath)
+	if err != nil {
+		return Metadata{}
+	}
+	var sidecarTime time.Time
+	if sidecarPath != "" {
+		if sidecarInfo, err := storage.Root.Stat(sidecarPath); err == nil {
+			sidecarTime = sidecarInfo.ModTime()
+		} else {
+			sidecarPath = ""
+		}
+	}
+	metadataCacheMu.RLock()
+	cached, ok := metadataCache[fullPath]
+	metadataCacheMu.RUnlock()
+	if ok && cached.fileTime.Equal(fileInfo.ModTime()) &&
+		cached.sidecarTime.Equal(sidecarTime) {
+		return cached.meta
+	}
+
+	var packet []byte
+	if sidecarPath != "" {
+		packet, _ = readSidecar(sidecarPath)
+	}
+	if packet == nil {
+		packet = readEmbeddedXmp(fullPath)
+	}
+	meta, err := parseXmp(packet)
+	if err != nil {
+		(*logger).Printf("xmp error in %v: %v", fullPath, err)
+	}
+	metadataCacheMu.Lock()
+	metadataCache[fullPath] = cachedMetadata{
+		fileTime: fileInfo.ModTime(), sidecarTime: sidecarTime, meta: meta}
+	metadataCacheMu.Unlock()
+	return meta
+}
+
+func readSidecar(sidecarPath string) ([]byte, error) {
+	file, err := storage.Root.Open(sidecarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, int64(xmpHeadSize)))
+}
+
+func readEmbeddedXmp(fullPath string) []byte {
+	file, err := storage.Root.Open(fullPath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	return readXmpPacket(file)
+}
+
+// Finds the XMP packet in the APP1 segments of a JPEG or near the start of
+// other files
+func readXmpPacket(file io.ReadSeeker) []byte {
+	if packet, err := jpegXmp(file); err == nil {
+		return packet
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	head, _ := io.ReadAll(io.LimitReader(file, int64(xmpHeadSize)))
+	return xmpFromBytes(head)
+}
+
+// Walks the JPEG segments up to the image data looking for XMP
+func jpegXmp(file io.ReadSeeker) ([]byte, error) {
+	segment, err := jpegSegment(file, 0xe1, jpegXmpHeader)
+	if err != nil {
+		return nil, err
+	}
+	return xmpFromBytes(segment), nil
+}
+
+// Walks the JPEG segments up to the image data looking for the first one
+// with the marker and data starting with prefix. The prefix is cut off.
+func jpegSegment(file io.Reader, segmentMarker byte, prefix []byte) ([]byte, error) {
+	reader := bufio.NewReader(file)
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(reader, marker[:2]); err != nil {
+		return nil, err
+	}
+	if marker[0] != 0xff || marker[1] != 0xd8 {
+		return nil, errors.New("not a jpeg")
+	}
+	for {
+		if _, err := io.ReadFull(reader, marker); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xff || marker[1] == 0xda { // Start of scan, not found
+			return nil, errors.New("segment not found")
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, errors.New("invalid jpeg segment")
+		}
+		if marker[1] != segmentMarker || length < len(prefix) {
+			if _, err := reader.Discard(length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(segment, prefix) {
+			return segment[len(prefix):], nil
+		}
+	}
+}
+
+// Cuts the x:xmpmeta element from data
+func xmpFromBytes(data []byte) []byte {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil
+	}
+	data = data[start:]
+	if end := bytes.Index(data, []byte("</x:xmpmeta>")); end > 0 {
+		data = data[:end+len("</x:xmpmeta>")]
+	}
+	return data
+}
+
+// Extracts title, caption (description), keywords (subject) and rating
+func parseXmp(packet []byte) (meta Metadata, err error) {
+	if len(packet) == 0 {
+		return
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	decoder.Strict = false
+	var (
+		property string // dc property or rating being read
+		inItem   bool   // inside rdf:li
+		text     strings.Builder
+	)
+	for {
+		token, errToken := decoder.Token()
+		if errors.Is(errToken, io.EOF) {
+			return meta, nil
+		}
+		if errToken != nil {
+			return meta, errToken
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if attr.Name.Space == nsXmp && attr.Name.Local == "Rating" {
+					meta.Rating = parseRating(attr.Value)
+				}
+			}
+			switch {
+			case t.Name.Space == nsDc:
+				property = t.Name.Local
+			case t.Name.Space == nsXmp && t.Name.Local == "Rating":
+				property = "Rating"
+				text.Reset()
+			case t.Name.Space == nsRdf && t.Name.Local == "li":
+				inItem = true
+				text.Reset()
+			}
+		case xml.CharData:
+			if inItem || property == "Rating" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			switch {
+			case t.Name.Space == nsRdf && t.Name.Local == "li":
+				inItem = false
+				switch {
+				case value == "":
+				case property == "title" && meta.Title == "":
+					meta.Title = value
+				case property == "description" && meta.Caption == "":
+					meta.Caption = value
+				case property == "subject":
+					meta.Keywords = append(meta.Keywords, value)
+				}
+			case t.Name.Space == nsXmp && t.Name.Local == "Rating":
+				meta.Rating = parseRating(value)
+				property = ""
+			case t.Name.Space == nsDc:
+				property = ""
+			}
+		}
+	}
+}
+
+func parseRating(value string) int {
+	rating, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	return int(rating)
+}
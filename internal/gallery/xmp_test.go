@@ -0,0 +1,84 @@
+package gallery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const testXmp = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="3">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>
+<dc:description><rdf:Alt><rdf:li xml:lang="x-default"> Over the bay </rdf:li></rdf:Alt></dc:description>
+<dc:subject><rdf:Bag><rdf:li>sea</rdf:li><rdf:li>Holiday</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func TestParseXmp(t *testing.T) {
+	tests := []struct {
+		packet string
+		want   Metadata
+	}{
+		{"", Metadata{}},
+		{testXmp, Metadata{Title: "Sunset", Caption: "Over the bay",
+			Keywords: []string{"sea", "Holiday"}, Rating: 3}},
+		{`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF
+			xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description
+			xmlns:xmp="http://ns.adobe.com/xap/1.0/"><xmp:Rating>5</xmp:Rating>
+			</rdf:Description></rdf:RDF></x:xmpmeta>`, Metadata{Rating: 5}},
+		{`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description
+			xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="-1"/></x:xmpmeta>`,
+			Metadata{Rating: -1}},
+	}
+	for _, tc := range tests {
+		result, err := parseXmp([]byte(tc.packet))
+		if err != nil {
+			t.Errorf("parseXmp(%q) error: %v", tc.packet, err)
+		}
+		if !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("parseXmp(%q) = %+v, want %+v", tc.packet, result, tc.want)
+		}
+	}
+}
+
+func TestFindSidecars(t *testing.T) {
+	names := []string{"a.jpg", "a.xmp", "b.cr2", "b.CR2.xmp", "c.jpg", "d.xmp", "e.txt", "e.xmp"}
+	want := map[string]string{"a.jpg": "a.xmp", "b.cr2": "b.CR2.xmp"}
+	if result := FindSidecars(names); !reflect.DeepEqual(result, want) {
+		t.Errorf("FindSidecars(%v) = %v, want %v", names, result, want)
+	}
+}
+
+func TestJpegXmp(t *testing.T) {
+	segment := func(marker byte, data []byte) []byte {
+		head := []byte{0xff, marker, 0, 0}
+		binary.BigEndian.PutUint16(head[2:], uint16(len(data)+2))
+		return append(head, data...)
+	}
+	exif := segment(0xe1, []byte("Exif\x00\x00 not xmp"))
+	xmp := segment(0xe1, append(append([]byte{}, jpegXmpHeader...), testXmp...))
+	scan := []byte{0xff, 0xda, 0, 2}
+
+	var jpeg []byte
+	jpeg = append(jpeg, 0xff, 0xd8)
+	jpeg = append(jpeg, exif...)
+	jpeg = append(jpeg, xmp...)
+	jpeg = append(jpeg, scan...)
+	if packet, err := jpegXmp(bytes.NewReader(jpeg)); err != nil || string(packet) != testXmp {
+		t.Errorf("jpegXmp() = %q, %v", packet, err)
+	}
+
+	noXmp := append(append([]byte{0xff, 0xd8}, exif...), scan...)
+	if _, err := jpegXmp(bytes.NewReader(noXmp)); err == nil {
+		t.Error("jpegXmp() found xmp where there is none")
+	}
+
+	if _, err := jpegXmp(bytes.NewReader([]byte(testXmp))); err == nil {
+		t.Error("jpegXmp() accepts files which are not jpeg")
+	}
+	if packet := readXmpPacket(bytes.NewReader([]byte("PNG..." + testXmp))); string(packet) != testXmp {
+		t.Errorf("readXmpPacket() = %q", packet)
+	}
+}
@@ -0,0 +1,102 @@
+package gallery
+
+import (
+	"archive/zip"
+	"io"
+	"math"
+	"time"
+
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+// File added to a ZIP archive
+type ZipEntry struct {
+	ModTime time.Time
+	Name    string // In the archive, with forward slashes
+	Path    string // Relative to the root folder
+	Size    int64
+}
+
+// Sizes of the records archive/zip writes for stored files, without names
+const (
+	zipLocalHeaderSize    = 30
+	zipTimeExtraSize      = 9 // Modification time as a Unix time
+	zipDescriptorSize     = 16
+	zipDescriptor64Size   = 24
+	zipCentralHeaderSize  = 46
+	zip64ExtraHeaderSize  = 4 // Followed by 8 bytes for each large field
+	zipEndSize            = 22
+	zip64EndAndLocatorLen = 56 + 20
+)
+
+// ZipSize is the exact length of the archive WriteZip makes of the entries.
+// It follows the records archive/zip writes, ZIP64 ones included, so that
+// the length of the archive is known before it is streamed.
+func ZipSize(entries []ZipEntry) int64 {
+	var offset, directory int64
+	zip64 := len(entries) >= math.MaxUint16
+	for _, entry := range entries {
+		name := int64(len(entry.Name))
+		var extra int64
+		if !entry.ModTime.IsZero() {
+			extra = zipTimeExtraSize
+		}
+		central := zipCentralHeaderSize + name + extra
+		var large int64
+		if entry.Size >= math.MaxUint32 {
+			large += 2 // Compressed and uncompressed sizes
+		}
+		if offset >= math.MaxUint32 {
+			large++
+		}
+		if large > 0 {
+			central += zip64ExtraHeaderSize + 8*large
+			zip64 = true
+		}
+		descriptor := int64(zipDescriptorSize)
+		if entry.Size > math.MaxUint32 {
+			descriptor = zipDescriptor64Size
+		}
+		offset += zipLocalHeaderSize + name + extra + entry.Size + descriptor
+		directory += central
+	}
+	size := offset + directory + zipEndSize
+	if zip64 || directory >= math.MaxUint32 || offset >= math.MaxUint32 {
+		size += zip64EndAndLocatorLen
+	}
+	return size
+}
+
+// WriteZip streams files from storage.Root as a ZIP archive of stored
+// (not compressed) entries. Media is compressed already and the length of
+// the archive is known before, see ZipSize.
+func WriteZip(w io.Writer, entries []ZipEntry) error {
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		if err := writeZipEntry(archive, entry); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func writeZipEntry(archive *zip.Writer, entry ZipEntry) error {
+	file, err := storage.Root.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name: entry.Name, Method: zip.Store, Modified: entry.ModTime})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(writer, io.LimitReader(file, entry.Size))
+	if err != nil {
+		return err
+	}
+	if n != entry.Size { // Changed since its size was read
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
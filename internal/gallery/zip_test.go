@@ -0,0 +1,149 @@
+package gallery
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"specto.org/projects/foldergal/internal/storage"
+)
+
+func TestWriteZip(t *testing.T) {
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = afero.NewMemMapFs()
+	files := map[string]string{
+		"trip/a.jpg":     "first image",
+		"trip/day/b.png": "",
+		"trip/ünï.mp4":   string(bytes.Repeat([]byte("video"), 1000)),
+	}
+	modTime := time.Date(2024, 7, 14, 10, 30, 20, 0, time.UTC)
+	var entries []ZipEntry
+	for _, name := range []string{"trip/a.jpg", "trip/day/b.png", "trip/ünï.mp4"} {
+		if err := afero.WriteFile(storage.Root, name, []byte(files[name]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, ZipEntry{Name: name[len("trip/"):], Path: name,
+			Size: int64(len(files[name])), ModTime: modTime})
+	}
+
+	var buf bytes.Buffer
+	if err := WriteZip(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	if size := ZipSize(entries); int64(buf.Len()) != size {
+		t.Errorf("written %d bytes, ZipSize = %d", buf.Len(), size)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.File) != len(entries) {
+		t.Fatalf("archive has %d files, want %d", len(reader.File), len(entries))
+	}
+	for i, file := range reader.File {
+		if file.Name != entries[i].Name {
+			t.Errorf("file %d is %q, want %q", i, file.Name, entries[i].Name)
+		}
+		if !file.Modified.Equal(modTime) {
+			t.Errorf("%s modified %v, want %v", file.Name, file.Modified, modTime)
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r) // Checks the CRC
+		r.Close()
+		if err != nil {
+			t.Errorf("%s: %v", file.Name, err)
+		} else if string(data) != files[entries[i].Path] {
+			t.Errorf("%s has other content", file.Name)
+		}
+	}
+
+	// Archives with more files than the end record counts use ZIP64
+	many := make([]ZipEntry, math.MaxUint16+1)
+	for i := range many {
+		many[i] = ZipEntry{Name: fmt.Sprintf("%d.jpg", i), Path: "trip/a.jpg",
+			Size: int64(len(files["trip/a.jpg"])), ModTime: modTime}
+	}
+	buf.Reset()
+	if err := WriteZip(&buf, many); err != nil {
+		t.Fatal(err)
+	}
+	if size := ZipSize(many); int64(buf.Len()) != size {
+		t.Errorf("written %d bytes of %d files, ZipSize = %d", buf.Len(), len(many), size)
+	}
+	reader, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.File) != len(many) {
+		t.Errorf("archive has %d files, want %d", len(reader.File), len(many))
+	}
+
+	entries[0].Size++ // File changed since listed
+	if err := WriteZip(io.Discard, entries); err != io.ErrUnexpectedEOF {
+		t.Errorf("WriteZip of changed file = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// Files of zeros of any size, without storing them
+type zeroFs struct{ afero.Fs }
+
+type zeroFile struct{ afero.File }
+
+func (fs zeroFs) Open(name string) (afero.File, error) {
+	file, err := fs.Fs.Open(name)
+	return zeroFile{file}, err
+}
+
+func (zeroFile) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+func TestZipSizeZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes archives over 4 GiB")
+	}
+	root := storage.Root
+	defer func() { storage.Root = root }()
+	storage.Root = zeroFs{afero.NewMemMapFs()}
+	if err := afero.WriteFile(storage.Root, "large.mp4", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 7, 14, 10, 30, 20, 0, time.UTC)
+	tests := [][]int64{
+		{math.MaxUint32 - 1, 10},
+		{math.MaxUint32},
+		{math.MaxUint32 + 1, 5},
+		{10, math.MaxUint32 - 200, 300}, // The last file starts after 4 GiB
+	}
+	for _, sizes := range tests {
+		var entries []ZipEntry
+		for i, size := range sizes {
+			entries = append(entries, ZipEntry{Name: fmt.Sprintf("%d.mp4", i),
+				Path: "large.mp4", Size: size, ModTime: modTime})
+		}
+		var written countingWriter
+		if err := WriteZip(&written, entries); err != nil {
+			t.Fatal(err)
+		}
+		if size := ZipSize(entries); int64(written) != size {
+			t.Errorf("written %d bytes of files %v, ZipSize = %d", written, sizes, size)
+		}
+	}
+}
@@ -20,5 +20,5 @@ var (
 
 func init() {
 	InternalHttp = http.FS(embedded)
-	Internal = afero.NewReadOnlyFs(afero.FromIOFS{embedded})
+	Internal = afero.NewReadOnlyFs(afero.FromIOFS{FS: embedded})
 }
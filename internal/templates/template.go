@@ -4,6 +4,7 @@ import (
 	"fmt"
 	htmlTpl "html/template"
 	"io"
+	"strings"
 	textTpl "text/template"
 	"time"
 
@@ -26,38 +27,124 @@ type Page struct {
 	LinkNext     string
 	ParentUrl    string
 	ParentName   string
+	SearchTerm   string
 	ShowOverlay  bool
 }
 
+// Other file of the same media, like a RAW image or a sidecar
+type Alternate struct {
+	Name string
+	Url  string
+}
+
 type ListItem struct {
-	ModTime time.Time
-	Id      string
+	ModTime     time.Time
+	Taken       time.Time // Capture time or the modification time when unknown
+	Alternates  []Alternate
+	Keywords    []string
+	Id          string
+	Url         string
+	Path        string // Relative to the root folder
+	Sidecar     string // Relative path of the XMP sidecar
+	CaptionFile string // Relative path of the text file with the caption
+	Name        string
+	Title       string
+	Caption     string
+	Alt         string
+	Thumb       string
+	Class       string
+	Type        string // Upper case extension of files
+	Motion      string
+	Size        int64
+	Duration    time.Duration
+	Width       int // Of the media, when known
+	Height      int
+	Rating      int
+	Position    int  // In the custom sort of its folder, 0 when not listed
+	Pinned      bool // Featured first in its folder
+	Selectable  bool // Can be ticked for a ZIP download
+	W           int
+	H           int
+}
+
+func (li ListItem) IsFolder() bool { return li.Class == "folder" }
+
+// Class without modifiers like "nothumb"
+func (li ListItem) BaseClass() string {
+	class, _, _ := strings.Cut(li.Class, " ")
+	return class
+}
+
+// Link in a group of toggles like filters
+type Link struct {
+	Title   string
 	Url     string
-	Name    string
-	Thumb   string
-	Class   string
-	W       int
-	H       int
+	Current bool
 }
 
 // Page used for folder list
 type List struct {
-	Items       []ListItem
-	BreadCrumbs []BreadCrumb
+	Items        []ListItem
+	BreadCrumbs  []BreadCrumb
+	SortLinks    []Link
+	ColumnLinks  []Link // Headers of the table sorting by their column
+	ClassLinks   []Link
+	RatingLinks  []Link
+	KeywordLinks []Link
+	PageLinks    []Link
+	AlbumLinks   []Link
+	// Downloads of the folder as ZIP files
+	DownloadLinks []Link
+	Page
+	Description   string
+	Copyright     string
+	ParentUrl     string
+	LinkPrev      string
+	LinkNext      string
+	LinkOrderAsc  string
+	LinkOrderDesc string
+	LinkFolders   string
+	LinkRecursive string
+	LinkTable     string
+	LinkTimeline  string
+	LinkReset     string // Forgets the sort, order and display of the user
+	ZipAction     string // Downloads the ticked items, empty when disabled
+	LinkShare     string // Form to share the folder, for those who can
+	ItemCount     string
+	DisplayMode   string
+	IsReversed    bool
+	IsRecursive   bool
+	IsTable       bool
+	IsAlbum       bool // Media of an album, in its order
+}
+
+// Media taken on a day
+type TimelineDay struct {
+	Id    string
+	Title string
+	Items []ListItem
+}
+
+type TimelineMonth struct {
+	Id    string
+	Title string
+	Days  []TimelineDay
+}
+
+// Page with the media of a folder and its subfolders by date, a year at
+// once or the memories of the day
+type Timeline struct {
 	Page
-	Description    string
-	Copyright      string
-	ParentUrl      string
-	LinkPrev       string
-	LinkNext       string
-	LinkOrderAsc   string
-	LinkOrderDesc  string
-	LinkSortName   string
-	LinkSortDate   string
-	ItemCount      string
-	DisplayMode    string
-	IsSortedByName bool
-	IsReversed     bool
+	BreadCrumbs   []BreadCrumb
+	DateLinks     []Link // Timeline and memories
+	YearLinks     []Link
+	MonthLinks    []Link // Jumps to sections
+	Months        []TimelineMonth
+	LinkOrderAsc  string
+	LinkOrderDesc string
+	ItemCount     string
+	Copyright     string
+	IsReversed    bool
 }
 
 type ErrorPage struct {
@@ -65,19 +152,52 @@ type ErrorPage struct {
 	Message string
 }
 
+// Form to log in, or the account of the logged in user
+type LoginPage struct {
+	Page
+	Copyright string
+	Message   string
+	Next      string // Where to go after logging in
+	User      string
+	FeedUrl   string // With the token of the user
+}
+
+// Form to unlock a folder with a password
+type UnlockPage struct {
+	Page
+	Copyright string
+	Message   string
+	Action    string // Unlock route of the folder
+	Next      string // Where to go after unlocking
+}
+
+// Form to share a folder or file, and the share link made with it
+type SharePage struct {
+	Page
+	Copyright string
+	Message   string
+	Action    string // Share route of the folder or file
+	Link      string
+	Id        string // To revoke the link
+	Expires   time.Time
+}
+
 type TwoColTable struct {
 	Page
 	Rows [][2]string
 }
 
 type FeedItem struct {
-	Title string
-	Type  string
-	Url   string
-	Thumb string
-	Id    string
-	Date  string
-	Mdate time.Time
+	Title       string
+	Description string
+	Copyright   string
+	Path        string // Path in the file system
+	Type        string
+	Url         string
+	Thumb       string
+	Id          string
+	Date        string
+	Mdate       time.Time
 }
 
 type FeedPage struct {
@@ -90,7 +210,16 @@ type FeedPage struct {
 
 type ViewPage struct {
 	Page
-	MediaPath string
+	Alternates []Alternate
+	Keywords   []Link
+	MediaPath  string
+	MotionPath string
+	LinkShare  string // Form to share the media, for those who can
+	Heading    string
+	Caption    string
+	Alt        string
+	Copyright  string
+	Rating     int
 }
 
 var (
@@ -102,6 +231,34 @@ func formatDate(date time.Time) string {
 	return date.In(config.Global.TimeLocation).Format("2006-01-02 15:04 Z07")
 }
 
+// Size in bytes with binary units, like 1.5 MiB
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, unit := float64(size)/1024, 0
+	for value >= 1024 && unit < 3 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, []string{"KiB", "MiB", "GiB", "TiB"}[unit])
+}
+
+// Duration of media like 1:02:03 or 2:03
+func formatDuration(duration time.Duration) string {
+	seconds := int(duration.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Rating as a row of stars
+func stars(rating int) string {
+	rating = max(0, min(rating, config.MaxRating))
+	return strings.Repeat("★", rating) + strings.Repeat("☆", config.MaxRating-rating)
+}
+
 func parseHtmlTemplates(templs ...string) (t *htmlTpl.Template, err error) {
 	t = htmlTpl.New("_all")
 
@@ -112,7 +269,9 @@ func parseHtmlTemplates(templs ...string) (t *htmlTpl.Template, err error) {
 		}
 		listBytes, _ := io.ReadAll(listFile)
 		if _, err = t.New(fmt.Sprint("_", i)).Funcs(
-			htmlTpl.FuncMap{"formatDate": formatDate},
+			htmlTpl.FuncMap{"formatDate": formatDate, "stars": stars,
+				"formatSize": formatSize, "formatDuration": formatDuration,
+				"authEnabled": config.AuthEnabled},
 		).Parse(string(listBytes)); err != nil {
 			return
 		}
@@ -147,9 +306,11 @@ func init() {
 		"res/templates/list.html",
 		"res/templates/footer.html",
 		"res/templates/error.html",
+		"res/templates/login.html",
 		"res/templates/layout.html",
 		"res/templates/view.html",
 		"res/templates/table.html",
+		"res/templates/timeline.html",
 	)
 	if err != nil {
 		panic(err)